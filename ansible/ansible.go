@@ -19,10 +19,12 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -44,10 +46,46 @@ type AnsibleRunner struct {
 	Config      *config.Config
 	debug       int
 	araPath     string
+	araMtx      sync.Mutex
 }
 
 func NewRunner(logger log.Logger, cfg *config.Config, debug int, ansiblePath, depsPath string, inventory *ansible.Inventory) (*AnsibleRunner, error) {
-	i := *inventory
+	var becomePass string
+	if cfg.Global.AnsibleBecomePasswordFile != "" {
+		d, err := os.ReadFile(cfg.Global.AnsibleBecomePasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		becomePass = strings.TrimSpace(string(d))
+	}
+	i := connectionInventory(logger, cfg, inventory, becomePass)
+
+	data, err := yaml.Marshal(i)
+	if err == nil {
+		level.Debug(logger).Log("msg", "Ansible inventory", "inventory", string(data))
+	}
+
+	return &AnsibleRunner{
+		Logger:      logger,
+		AnsiblePath: ansiblePath,
+		DepsPath:    depsPath,
+		Inventory:   i,
+		Config:      cfg,
+		debug:       debug,
+	}, nil
+}
+
+// connectionInventory returns a copy of inventory with the variables Ansible
+// needs to connect to the hosts. becomePass is the value of
+// ansible_become_pass, that is only set if a become password file is
+// configured.
+func connectionInventory(logger log.Logger, cfg *config.Config, inventory *ansible.Inventory, becomePass string) *ansible.Inventory {
+	i := ansible.Inventory{
+		Groups: make(map[string]ansible.Group, len(inventory.Groups)),
+	}
+	for name, gr := range inventory.Groups {
+		i.Groups[name] = gr
+	}
 
 	if _, ok := i.Groups["all"]; !ok {
 		i.Groups["all"] = ansible.Group{}
@@ -56,9 +94,11 @@ func NewRunner(logger log.Logger, cfg *config.Config, debug int, ansiblePath, de
 	if !ok {
 		panic("group should exist at this point")
 	}
-	if allGr.Variables == nil {
-		allGr.Variables = make(map[string]interface{})
+	vars := make(map[string]interface{}, len(allGr.Variables))
+	for k, v := range allGr.Variables {
+		vars[k] = v
 	}
+	allGr.Variables = vars
 
 	if cfg.Global.AnsibleTOFU {
 		level.Debug(logger).Log("msg", "Ansible TOFU enabled")
@@ -67,31 +107,16 @@ func NewRunner(logger log.Logger, cfg *config.Config, debug int, ansiblePath, de
 	allGr.Variables["ansible_user"] = cfg.Global.AnsibleUser
 	allGr.Variables["ansible_ssh_private_key_file"] = cfg.Global.AnsibleSSHKeyPath
 	if cfg.Global.AnsibleBecomePasswordFile != "" {
-		d, err := os.ReadFile(cfg.Global.AnsibleBecomePasswordFile)
-		if err != nil {
-			return nil, err
-		}
-		allGr.Variables["ansible_become_pass"] = strings.TrimSpace(string(d))
+		allGr.Variables["ansible_become_pass"] = becomePass
 	}
 
 	i.Groups["all"] = allGr
-
-	data, err := yaml.Marshal(&i)
-	if err == nil {
-		level.Debug(logger).Log("msg", "Ansible inventory", "inventory", string(data))
-	}
-
-	return &AnsibleRunner{
-		Logger:      logger,
-		AnsiblePath: ansiblePath,
-		DepsPath:    depsPath,
-		Inventory:   &i,
-		Config:      cfg,
-		debug:       debug,
-	}, nil
+	return &i
 }
 
 func (ar *AnsibleRunner) FindARAPath() (string, error) {
+	ar.araMtx.Lock()
+	defer ar.araMtx.Unlock()
 	if ar.araPath != "" {
 		return ar.araPath, nil
 	}
@@ -115,12 +140,23 @@ func (ar *AnsibleRunner) FindARAPath() (string, error) {
 }
 
 func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.Playbook, skipTags []string, limit string) error {
+	_, err := ar.runPlaybooks(ctx, playbooks, skipTags, limit, nil)
+	return err
+}
+
+// RunPlaybooksTo runs the playbooks like RunPlaybooks, but writes the output
+// of ansible-playbook to w and returns the report instead of printing it.
+func (ar *AnsibleRunner) RunPlaybooksTo(ctx context.Context, playbooks []*ansible.Playbook, skipTags []string, limit string, w io.Writer) (Report, error) {
+	return ar.runPlaybooks(ctx, playbooks, skipTags, limit, w)
+}
+
+func (ar *AnsibleRunner) runPlaybooks(ctx context.Context, playbooks []*ansible.Playbook, skipTags []string, limit string, out io.Writer) (Report, error) {
 	if len(playbooks) == 0 {
-		return errors.New("No playbooks!")
+		return nil, errors.New("No playbooks!")
 	}
 	rolesPath, err := extractGz(roles)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if ar.debug > 0 {
@@ -132,16 +168,16 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 
 	homeDir, err := os.MkdirTemp("", "home")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer os.RemoveAll(homeDir)
 	if err := os.MkdirAll(filepath.Join(homeDir, ".ara", "server", "www", "static"), 0777); err != nil {
-		return err
+		return nil, err
 	}
 
 	cfgFile, err := ar.writeConfig(rolesPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if ar.debug > 0 {
@@ -153,7 +189,7 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 
 	inventoryFile, err := write(ar.Inventory)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if ar.debug > 0 {
@@ -165,7 +201,7 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 
 	playbookFile, err := write(playbooks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if ar.debug > 0 {
@@ -177,7 +213,7 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 
 	callbackFile, err := os.CreateTemp("", "ansible_log.json")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if ar.debug > 0 {
@@ -200,7 +236,10 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 	errWriter := writer.NewBufferedWriter(cmdWriter)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if ar.debug == 0 {
+	if out != nil {
+		cmd.Stdout = out
+		cmd.Stderr = out
+	} else if ar.debug == 0 {
 		cmd.Stdout = cmdWriter
 		cmd.Stderr = errWriter
 	}
@@ -222,7 +261,7 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 		araPath, err := ar.FindARAPath()
 		if err != nil {
 			ar.Logger.Log("msg", "Error getting ARA Path", "err", err)
-			return err
+			return nil, err
 		}
 		if ar.debug > 0 {
 			fmt.Printf("ARA Enabled at %q\n", araPath)
@@ -244,20 +283,45 @@ func (ar *AnsibleRunner) RunPlaybooks(ctx context.Context, playbooks []*ansible.
 
 	err = cmd.Run()
 
+	if out != nil {
+		report, rerr := readJSONReport(callbackFile.Name())
+		if rerr != nil {
+			// Without it, the hosts of the playbooks are missing from the
+			// report.
+			level.Warn(ar.Logger).Log("msg", "could not read the playbook report", "playbooks", playbookNames(playbooks), "err", rerr)
+		}
+		if err != nil {
+			ar.Logger.Log("msg", "Error running playbook", "err", err)
+			return report, err
+		}
+		return report, nil
+	}
+
 	if ar.debug == 0 {
 		errWriter.WriteAll(os.Stderr)
 	}
 
-	readAndPrintJSONReport(callbackFile.Name())
+	if rerr := readAndPrintJSONReport(callbackFile.Name()); rerr != nil {
+		level.Warn(ar.Logger).Log("msg", "could not read the playbook report", "playbooks", playbookNames(playbooks), "err", rerr)
+	}
 
 	if err != nil {
 		ar.Logger.Log("msg", "Error running playbook", "err", err)
-		return err
+		return nil, err
 	}
 
 	ar.Logger.Log("msg", "Playbook execution completed")
 
-	return nil
+	return nil, nil
+}
+
+// playbookNames returns the names of the playbooks, separated by commas.
+func playbookNames(playbooks []*ansible.Playbook) string {
+	names := make([]string, len(playbooks))
+	for i, pb := range playbooks {
+		names[i] = pb.Name
+	}
+	return strings.Join(names, ",")
 }
 
 func write(i interface{}) (string, error) {
@@ -285,10 +349,7 @@ func (ar *AnsibleRunner) writeConfig(rolesPath string) (string, error) {
 	}
 	// Write the ansible.cfg content to the
 	// temporary file
-	cfgContent := fmt.Sprintf(`
-[defaults]
-roles_path = %s
-`, rolesPath)
+	cfgContent := configContent(rolesPath)
 
 	if _, err := cfgFile.Write([]byte(cfgContent)); err != nil {
 		return "", err
@@ -299,6 +360,13 @@ roles_path = %s
 	return cfgFile.Name(), nil
 }
 
+func configContent(rolesPath string) string {
+	return fmt.Sprintf(`
+[defaults]
+roles_path = %s
+`, rolesPath)
+}
+
 func Ping() []*ansible.Playbook {
 	return []*ansible.Playbook{
 		&ansible.Playbook{
@@ -0,0 +1,70 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExportRoles extracts the embedded roles into dir/roles and writes an
+// ansible.cfg next to them, so dir can be used as an Ansible project.
+func ExportRoles(dir string) error {
+	rolesPath := filepath.Join(dir, "roles")
+	if err := os.MkdirAll(rolesPath, 0755); err != nil {
+		return err
+	}
+	if err := extractGzTo(roles, rolesPath); err != nil {
+		return fmt.Errorf("could not extract roles: %w", err)
+	}
+	// Relative paths in ansible.cfg are relative to the file itself.
+	return os.WriteFile(filepath.Join(dir, "ansible.cfg"), []byte(configContent("roles")), 0644)
+}
+
+// Export writes the inventory and the playbooks that RunPlaybooks would
+// execute into dir, as inventory.yml and playbook.yml. The become password is
+// not read: the inventory looks it up from its file when the playbook runs.
+func Export(logger log.Logger, cfg *config.Config, dir string, inventory *ansible.Inventory, playbooks []*ansible.Playbook) error {
+	var becomePass string
+	if cfg.Global.AnsibleBecomePasswordFile != "" {
+		// Relative paths would be looked up from the playbook directory.
+		path, err := filepath.Abs(cfg.Global.AnsibleBecomePasswordFile)
+		if err != nil {
+			return err
+		}
+		becomePass = fmt.Sprintf("{{ lookup('file', %q) | trim }}", path)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "inventory.yml"), connectionInventory(logger, cfg, inventory, becomePass)); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "playbook.yml"), playbooks)
+}
+
+func writeFile(path string, i interface{}) error {
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
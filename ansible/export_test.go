@@ -0,0 +1,38 @@
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+)
+
+func TestExportDoesNotReadBecomePassword(t *testing.T) {
+	cfg := &config.Config{}
+	// The file does not exist: only the runner reads it.
+	cfg.Global.AnsibleBecomePasswordFile = "secrets/become"
+	abs, err := filepath.Abs("secrets/become")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	inventory := &ansible.Inventory{Groups: map[string]ansible.Group{}}
+	if err := Export(log.NewNopLogger(), cfg, dir, inventory, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "inventory.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), abs) {
+		t.Fatalf("expected the inventory to look up %s, got:\n%s", abs, data)
+	}
+	if _, ok := inventory.Groups["all"]; ok {
+		t.Fatal("expected the inventory not to be modified")
+	}
+}
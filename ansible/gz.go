@@ -30,10 +30,15 @@ func extractGz(archive []byte) (string, error) {
 		return "", err
 	}
 
+	return tempDir, extractGzTo(archive, tempDir)
+}
+
+// Extracts the embedded tar.gz file to the given directory
+func extractGzTo(archive []byte, dir string) error {
 	archiveReader := bytes.NewReader(archive)
 	gzipReader, err := gzip.NewReader(archiveReader)
 	if err != nil {
-		return "", err
+		return err
 	}
 	tarReader := tar.NewReader(gzipReader)
 
@@ -43,30 +48,30 @@ func extractGz(archive []byte) (string, error) {
 			if err == io.EOF {
 				break
 			}
-			return "", err
+			return err
 		}
 
-		path := filepath.Join(tempDir, header.Name)
+		path := filepath.Join(dir, header.Name)
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(path, 0755); err != nil {
-				return "", err
+				return err
 			}
 		case tar.TypeReg:
 			file, err := os.Create(path)
 			if err != nil {
-				return "", err
+				return err
 			}
 			if _, err := io.Copy(file, tarReader); err != nil {
-				return "", err
+				return err
 			}
 			if err := file.Close(); err != nil {
-				return "", err
+				return err
 			}
 		default:
-			return "", err
+			return err
 		}
 	}
 
-	return tempDir, nil
+	return nil
 }
@@ -3,7 +3,10 @@ package ansible
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"sort"
 )
 
 type HostStatus struct {
@@ -17,52 +20,89 @@ type HostStatus struct {
 	Ignored   int `json:"ignored"`
 }
 
+// Report is the status of each host after an Ansible run.
+type Report map[string]HostStatus
+
+// Merge adds the statuses of another report to this one.
+func (r Report) Merge(other Report) {
+	for host, status := range other {
+		s := r[host]
+		s.Processed += status.Processed
+		s.Failures += status.Failures
+		s.Ok += status.Ok
+		s.Dark += status.Dark
+		s.Changed += status.Changed
+		s.Skipped += status.Skipped
+		s.Rescued += status.Rescued
+		s.Ignored += status.Ignored
+		r[host] = s
+	}
+}
+
 func readAndPrintJSONReport(filename string) error {
+	statuses, err := readJSONReport(filename)
+	if err != nil {
+		return err
+	}
+	PrintReport(os.Stdout, statuses)
+	return nil
+}
+
+func readJSONReport(filename string) (Report, error) {
 	// Read the JSON file
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Unmarshal the JSON data into a map
-	var statuses map[string]HostStatus
+	var statuses Report
 	if err := json.Unmarshal(data, &statuses); err != nil {
-		return err
+		return nil, err
 	}
+	return statuses, nil
+}
 
+// PrintReport writes the report as a table to w.
+func PrintReport(w io.Writer, statuses Report) {
 	// ANSI escape codes for colors
 	red := "\033[31m"
 	green := "\033[32m"
 	reset := "\033[0m"
 
 	// Print header
-	fmt.Printf("%-25s  %-10s  %-10s  %-10s  %-12s  %-10s  %-10s  %-10s %-10s\n",
+	fmt.Fprintf(w, "%-25s  %-10s  %-10s  %-10s  %-12s  %-10s  %-10s  %-10s %-10s\n",
 		"Host", "Processed", "Failures", "Ok", "Unreachable", "Changed", "Skipped", "Rescued", "Ignored")
 
+	hosts := make([]string, 0, len(statuses))
+	for host := range statuses {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
 	// Iterate over each host and print its status
-	for host, status := range statuses {
-		fmt.Printf("%-25s  ", host)
-		fmt.Printf("%-10d  ", status.Processed)
+	for _, host := range hosts {
+		status := statuses[host]
+		fmt.Fprintf(w, "%-25s  ", host)
+		fmt.Fprintf(w, "%-10d  ", status.Processed)
 		if status.Failures > 0 {
-			fmt.Printf("%s%-10d%s  ", red, status.Failures, reset)
+			fmt.Fprintf(w, "%s%-10d%s  ", red, status.Failures, reset)
 		} else {
-			fmt.Printf("%-10d  ", status.Failures)
+			fmt.Fprintf(w, "%-10d  ", status.Failures)
 		}
 		if status.Ok > 0 {
-			fmt.Printf("%s%-10d%s  ", green, status.Ok, reset)
+			fmt.Fprintf(w, "%s%-10d%s  ", green, status.Ok, reset)
 		} else {
-			fmt.Printf("%-10d  ", status.Ok)
+			fmt.Fprintf(w, "%-10d  ", status.Ok)
 		}
 		if status.Dark > 0 {
-			fmt.Printf("%s%-12d%s  ", red, status.Dark, reset)
+			fmt.Fprintf(w, "%s%-12d%s  ", red, status.Dark, reset)
 		} else {
-			fmt.Printf("%-12d  ", status.Dark)
+			fmt.Fprintf(w, "%-12d  ", status.Dark)
 		}
-		fmt.Printf("%-10d  ", status.Changed)
-		fmt.Printf("%-10d  ", status.Skipped)
-		fmt.Printf("%-10d  ", status.Rescued)
-		fmt.Printf("%-10d\n", status.Ignored)
+		fmt.Fprintf(w, "%-10d  ", status.Changed)
+		fmt.Fprintf(w, "%-10d  ", status.Skipped)
+		fmt.Fprintf(w, "%-10d  ", status.Rescued)
+		fmt.Fprintf(w, "%-10d\n", status.Ignored)
 	}
-
-	return nil
 }
@@ -15,14 +15,18 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/rulefmt"
 	"github.com/roidelapluie/o11y-deploy/modules"
+	"github.com/roidelapluie/o11y-deploy/rules"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,6 +48,11 @@ type Global struct {
 	AnsibleTOFU               bool           `yaml:"ansible_trust_on_firs_use"`
 	EnableARA                 bool           `yaml:"enable_ara"`
 	ARAListen                 string         `yaml:"ara_listen_address"`
+	FailOnEmptyTargetGroups   bool           `yaml:"fail_on_empty_target_groups"`
+
+	// ScopeModuleRules restricts the rules of the modules to the series of
+	// their target group, like the rules of the target groups.
+	ScopeModuleRules bool `yaml:"scope_module_rules"`
 }
 
 var DefaultConfig = Config{}
@@ -70,11 +79,34 @@ type TargetGroup struct {
 	Name    string   `yaml:"name"`
 	Modules *Modules `yaml:"modules"`
 	Targets *Targets `yaml:"targets"`
+
+	// Scrape overrides how the targets of every module of the target group
+	// are scraped. The scrape settings of a module take precedence.
+	Scrape modules.ScrapeSettings `yaml:"scrape"`
+
+	// RuleFiles are globs of Prometheus rules files, and Rules are inline
+	// rule groups. They are deployed with the rules of the modules, scoped to
+	// the target group.
+	RuleFiles []string            `yaml:"rule_files,omitempty"`
+	Rules     []rulefmt.RuleGroup `yaml:"rules,omitempty"`
+
+	// AlertOverrides disable or change the alerts of the modules, by alert
+	// name.
+	AlertOverrides rules.AlertOverrides `yaml:"alert_overrides,omitempty"`
+
+	// configFile is the configuration file the target group was loaded
+	// from, where its inline rules are.
+	configFile string
 }
 
 type Targets struct {
 	ServiceDiscoveryConfigs discovery.Configs `yaml:"-"`
 	RelabelConfigs          []*relabel.Config `yaml:"relabel_configs,omitempty"`
+
+	// ModuleRelabelConfigs select, by module name, the targets a module is
+	// enabled on. They are applied after RelabelConfigs, and only the
+	// targets they keep get the module. Label changes are discarded.
+	ModuleRelabelConfigs map[string][]*relabel.Config `yaml:"module_relabel_configs,omitempty"`
 }
 
 func (t *Targets) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -94,6 +126,9 @@ type Modules struct {
 
 func (t *TargetGroup) SetDirectory(directory string) {
 	t.Targets.SetDirectory(directory)
+	for i, f := range t.RuleFiles {
+		t.RuleFiles[i] = JoinDir(directory, f)
+	}
 }
 
 func (m *Modules) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -115,6 +150,60 @@ func (c *Config) SetDirectory(directory string) {
 	}
 }
 
+// Validate checks the configuration of the enabled modules of every target
+// group, and returns all the errors found.
+func (c *Config) Validate() []error {
+	var errs []error
+	for _, tg := range c.TargetGroups {
+		enabled := make(map[string]bool)
+		if tg.Modules != nil {
+			for _, mod := range tg.Modules.ModulesConfigs {
+				enabled[mod.Name()] = mod.IsEnabled()
+			}
+		}
+		if tg.Targets != nil {
+			names := make([]string, 0, len(tg.Targets.ModuleRelabelConfigs))
+			for name := range tg.Targets.ModuleRelabelConfigs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if !enabled[name] {
+					errs = append(errs, fmt.Errorf("target group %q: module_relabel_configs: module %q is not enabled", tg.Name, name))
+				}
+			}
+		}
+
+		if err := tg.Scrape.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target group %q: scrape: %w", tg.Name, err))
+		}
+
+		if _, _, err := tg.LoadRules(); err != nil {
+			errs = append(errs, fmt.Errorf("target group %q: %w", tg.Name, err))
+		}
+		if err := tg.AlertOverrides.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target group %q: alert_overrides: %w", tg.Name, err))
+		}
+
+		if tg.Modules == nil {
+			continue
+		}
+		for _, mod := range tg.Modules.ModulesConfigs {
+			if !mod.IsEnabled() {
+				continue
+			}
+			v, ok := mod.(modules.Validator)
+			if !ok {
+				continue
+			}
+			if err := v.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("target group %q: module %q: %w", tg.Name, mod.Name(), err))
+			}
+		}
+	}
+	return errs
+}
+
 func LoadFile(filePath string) (*Config, error) {
 	var config Config
 	config = DefaultConfig
@@ -136,6 +225,9 @@ func LoadFile(filePath string) (*Config, error) {
 		return nil, err
 	}
 	config.SetDirectory(dir)
+	for i := range config.TargetGroups {
+		config.TargetGroups[i].configFile = filePath
+	}
 
 	return &config, nil
 }
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v2"
@@ -37,3 +41,90 @@ target_groups:
 		t.Fatalf("Expected 1 module, got %v", n)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: servers
+    modules:
+      grafana_module:
+        enabled: true
+        users_role: Boss
+      prometheus_module:
+        enabled: false
+        listen_port: http
+    targets:
+      static_configs:
+      - targets:
+          - 'localhost:22'
+`
+	var c Config
+	err := yaml.Unmarshal([]byte(yamlString), &c)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	errs := c.Validate()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	expected := `target group "servers": module "grafana": users_role: invalid role "Boss", must be one of Viewer, Editor or Admin`
+	if errs[0].Error() != expected {
+		t.Fatalf("Expected error %q, got %q", expected, errs[0].Error())
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rules", "a.yml"), []byte(`
+groups:
+- name: from-file
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "o11y.yml"), []byte(`
+target_groups:
+  - name: servers
+    targets:
+      static_configs:
+      - targets: ['localhost:22']
+    rule_files:
+    - rules/*.yml
+    rules:
+    - name: inline
+      rules:
+      - alert: Down
+        expr: up == 0
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadFile(filepath.Join(dir, "o11y.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgs, files, err := c.TargetGroups[0].LoadRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rgs) != 2 || rgs[0].Name != "from-file" || rgs[1].Name != "inline" {
+		t.Fatalf("unexpected rule groups %v", rgs)
+	}
+	expected := []string{filepath.Join(dir, "rules", "a.yml"), filepath.Join(dir, "o11y.yml")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected files %v, got %v", expected, files)
+	}
+
+	// The errors of the inline rules point at the configuration file.
+	c.TargetGroups[0].Rules[0].Rules[0].Expr.Value = "up =="
+	_, _, err = c.TargetGroups[0].LoadRules()
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "o11y.yml")+`:12:16: group "inline", rule 1, "Down": could not parse expression`) {
+		t.Fatalf("expected an error with an invalid expression, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+// LoadRules returns the rule groups of the rule files of the target group,
+// in the order of the patterns then of the file names, followed by its inline
+// rule groups, and the files they were read from, by index. The inline rule
+// groups come from the configuration file. The rule groups are validated like
+// Prometheus does. Patterns that do not match any file are ignored, like
+// Prometheus does.
+func (t *TargetGroup) LoadRules() ([]rulefmt.RuleGroup, []string, error) {
+	var (
+		rgs   []rulefmt.RuleGroup
+		files []string
+	)
+	for _, pattern := range t.RuleFiles {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rule_files: %w", err)
+		}
+		sort.Strings(matches)
+		for _, f := range matches {
+			// The errors start with the name of the file.
+			groups, errs := rulefmt.ParseFile(f)
+			if len(errs) > 0 {
+				return nil, nil, fmt.Errorf("rule_files: %w", errs[0])
+			}
+			for _, g := range groups.Groups {
+				rgs = append(rgs, g)
+				files = append(files, f)
+			}
+		}
+	}
+
+	if len(t.Rules) > 0 {
+		// The inline rules are validated as they were read, so that the
+		// errors point at the configuration file.
+		inline := make([]string, len(t.Rules))
+		for i := range inline {
+			inline[i] = t.configFile
+		}
+		if errs := rules.Validate(t.Rules, inline); len(errs) > 0 {
+			return nil, nil, fmt.Errorf("rules: %w", errs[0])
+		}
+		rgs = append(rgs, t.Rules...)
+		files = append(files, inline...)
+	}
+	return rgs, files, nil
+}
@@ -15,10 +15,14 @@ package deploy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
@@ -27,13 +31,11 @@ import (
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"github.com/prometheus/prometheus/model/labels"
-	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/rulefmt"
 	"github.com/roidelapluie/o11y-deploy/ansible"
 	"github.com/roidelapluie/o11y-deploy/config"
 	"github.com/roidelapluie/o11y-deploy/model/amserver"
 	ansiblemodel "github.com/roidelapluie/o11y-deploy/model/ansible"
-	"github.com/roidelapluie/o11y-deploy/model/ctx"
 	"github.com/roidelapluie/o11y-deploy/model/promserver"
 	"github.com/roidelapluie/o11y-deploy/modules"
 )
@@ -57,139 +59,309 @@ func NewDeployer(logger log.Logger, cfg *config.Config, homeDeps string, ansible
 }
 
 // Run executes the deployment process and returns an error if anything goes wrong.
-func (d *Deployer) Run(enabledModules, skipTags []string, limit string) error {
-	// Validate the configuration before proceeding with the deployment
-	err := d.validateConfig()
+// If parallelGroups is greater than one, each target group is deployed in its
+// own Ansible run, with up to parallelGroups runs at a time.
+func (d *Deployer) Run(enabledModules, skipTags []string, limit string, parallelGroups int) error {
+	plan, err := d.plan(enabledModules, d.cfg.Global.DataDir, d.cfg.Global.DataDir, false)
 	if err != nil {
 		return err
 	}
 
-	level.Debug(d.logger).Log("msg", "Starting deployment...")
+	// Take the snapshot before running, the runner adds its own variables to
+	// the inventories.
+	state, err := plan.State()
+	if err != nil {
+		return err
+	}
 
-	c := context.Background()
-	c = ctx.SetDatadir(c, d.cfg.Global.DataDir)
+	ar, err := ansible.NewRunner(d.logger, d.cfg, d.ansibleDebug, filepath.Join(d.homeDeps, "bin", "ansible-playbook"), d.homeDeps, plan.Inventory)
+	if err != nil {
+		return err
+	}
+	if parallelGroups > 1 {
+		err = d.runGroups(plan, ar, skipTags, limit, parallelGroups)
+	} else {
+		err = ar.RunPlaybooks(context.Background(), append(ansible.Ping(), plan.Playbooks()...), skipTags, limit)
+	}
+	if err != nil {
+		return err
+	}
 
-	// Check if the directory already exists
-	if _, err := os.Stat(d.cfg.Global.DataDir); os.IsNotExist(err) {
-		// If the directory does not exist, create it
-		if err := os.MkdirAll(d.cfg.Global.DataDir, 0755); err != nil {
-			level.Error(d.logger).Log("msg", "Error creating data directory", "err", err, "path", d.cfg.Global.DataDir)
+	// A partial deployment does not reflect what is on the hosts.
+	if len(enabledModules) == 0 && limit == "" {
+		if err := state.Save(d.cfg.Global.DataDir); err != nil {
+			level.Error(d.logger).Log("msg", "Error saving deployment state", "err", err)
 			return err
 		}
-	} else {
-		level.Error(d.logger).Log("msg", "Data directory present", "path", d.cfg.Global.DataDir)
-	}
-
-	moduleTargets := make(map[string][]labels.Labels)
-	prometheusTargets := make(map[string]map[string][]labels.Labels)
-	ruleGroups := []rulefmt.RuleGroup{}
-	dashboards := [][]byte{}
-	dashboardFiles := make(map[string][]byte)
-	promServers := []promserver.PrometheusServer{}
-	amServers := []amserver.AlertmanagerServer{}
-	reverseProxyEntries := make([]modules.ReverseProxyEntry, 0)
-	lb := labels.NewBuilder(labels.EmptyLabels())
+	}
+
+	level.Info(d.logger).Log("msg", "Deployment done")
+	return nil
+}
+
+// Diff renders the deployment and writes its differences with the last
+// successful deployment to w. It returns false if there are none.
+func (d *Deployer) Diff(w io.Writer, enabledModules []string) (bool, error) {
+	deployed, err := LoadState(d.cfg.Global.DataDir)
+	if err != nil {
+		return false, err
+	}
+
+	plan, err := d.Plan(enabledModules)
+	if err != nil {
+		return false, err
+	}
+	current, err := plan.State()
+	if err != nil {
+		return false, err
+	}
+	if len(enabledModules) > 0 {
+		deployed = deployed.Modules(enabledModules)
+	}
+
+	return DiffStates(w, deployed, current), nil
+}
+
+// Plan runs service discovery and renders the inventories and playbooks of
+// every target group, without running Ansible. The data directory is not
+// changed: the files that the playbooks would copy are written to a temporary
+// directory, which is removed before returning.
+func (d *Deployer) Plan(enabledModules []string) (*Plan, error) {
+	dir, err := os.MkdirTemp("", "o11y-plan")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	// The playbooks refer to the data directory, like the ones of a real
+	// deployment, so that the plan compares equal to the deployed state.
+	return d.plan(enabledModules, dir, d.cfg.Global.DataDir, true)
+}
+
+// plan renders the deployment. The files that the playbooks copy to the
+// hosts, such as Prometheus rules files, are written in filesDir, and the
+// playbooks refer to that directory as filesPath. If readOnly is set, the
+// modules do not write to the data directory.
+func (d *Deployer) plan(enabledModules []string, filesDir, filesPath string, readOnly bool) (*Plan, error) {
+	// Validate the configuration before proceeding with the deployment
+	err := d.validateConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	level.Debug(d.logger).Log("msg", "Starting deployment...")
+
+	// Plans and renders leave the data directory as it is, a deployment
+	// creates it if needed.
+	if !readOnly {
+		// Check if the directory already exists
+		if _, err := os.Stat(d.cfg.Global.DataDir); os.IsNotExist(err) {
+			// If the directory does not exist, create it
+			if err := os.MkdirAll(d.cfg.Global.DataDir, 0755); err != nil {
+				level.Error(d.logger).Log("msg", "Error creating data directory", "err", err, "path", d.cfg.Global.DataDir)
+				return nil, err
+			}
+		} else {
+			level.Error(d.logger).Log("msg", "Data directory present", "path", d.cfg.Global.DataDir)
+		}
+	}
+
+	rulesDir := filepath.Join(filesDir, "rules")
+	fileSDDir := filepath.Join(filesDir, "file_sd")
+	dashboardsDir := filepath.Join(filesDir, "dashboards")
+
+	order, err := moduleOrder(d.cfg)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		Model: &modules.DeploymentModel{
+			Global: modules.GlobalModel{
+				RulesDir:            rulesDir,
+				FileSDDir:           fileSDDir,
+				DashboardsDir:       dashboardsDir,
+				RulesPath:           path.Join(filesPath, "rules"),
+				FileSDPath:          path.Join(filesPath, "file_sd"),
+				DashboardsPath:      path.Join(filesPath, "dashboards"),
+				PrometheusServers:   []promserver.PrometheusServer{},
+				AlertmanagerServers: []amserver.AlertmanagerServer{},
+				Dashboards:          []json.RawMessage{},
+				ReverseProxyEntries: []modules.ReverseProxyEntry{},
+				DatasourceProxies:   []modules.DatasourceProxy{},
+			},
+		},
+		order: order,
+	}
+	global := &plan.Model.Global
+	groupTargets := make(map[string][]labels.Labels)
+	// groupModuleTargets are the targets of each module, by target group.
+	groupModuleTargets := make(map[string]map[string][]labels.Labels)
 	for _, targetGroup := range d.cfg.TargetGroups {
-		tgs := make([]labels.Labels, 0)
 		targets, err := PopulateTargets(d.logger, targetGroup.Targets, time.Duration(d.cfg.Global.SDSyncTime))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		for _, t := range targets {
-			for _, tg := range t.Targets {
-				lb.Reset(labels.EmptyLabels())
-
-				for ln, lv := range tg {
-					lb.Set(string(ln), string(lv))
-				}
-				for ln, lv := range t.Labels {
-					if _, ok := tg[ln]; !ok {
-						lb.Set(string(ln), string(lv))
-					}
-				}
-				if relabeled, keep := relabel.Process(lb.Labels(labels.EmptyLabels()), targetGroup.Targets.RelabelConfigs...); keep {
-					tgs = append(tgs, relabeled)
-				}
+		tgs, _ := relabelTargets(targets, targetGroup.Targets.RelabelConfigs)
+		if len(tgs) == 0 {
+			if d.cfg.Global.FailOnEmptyTargetGroups {
+				return nil, fmt.Errorf("target group %q has no targets", targetGroup.Name)
+			}
+			level.Warn(d.logger).Log("msg", "Target group has no targets", "target_group", targetGroup.Name)
+		}
+		groupTargets[targetGroup.Name] = tgs
+		groupModuleTargets[targetGroup.Name] = moduleTargets(targetGroup, tgs)
+		for name, mtgs := range groupModuleTargets[targetGroup.Name] {
+			if len(tgs) > 0 && len(mtgs) == 0 {
+				level.Warn(d.logger).Log("msg", "Module is not enabled on any target", "target_group", targetGroup.Name, "module", name)
 			}
 		}
-		moduleTargets[targetGroup.Name] = tgs
+	}
+
+	// Now that the discovered targets are known too, check that the target
+	// groups do not step on each other.
+	if err := validateTargets(d.cfg, groupModuleTargets); err != nil {
+		return nil, err
+	}
+
+	// Each module is created once per target group, and its instances share
+	// a store.
+	stores := make(map[string]*modules.Store)
+	groupModules := make([]map[string]modules.Module, len(d.cfg.TargetGroups))
+	for i, targetGroup := range d.cfg.TargetGroups {
+		groupModules[i], err = d.newModules(targetGroup, stores, readOnly)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, targetGroup := range d.cfg.TargetGroups {
+		tgs := groupTargets[targetGroup.Name]
+		gp := &GroupPlan{
+			Name:          targetGroup.Name,
+			Targets:       tgs,
+			ModuleTargets: groupModuleTargets[targetGroup.Name],
+		}
+		plan.Groups = append(plan.Groups, gp)
+
 		promTargets := make(map[string][]labels.Labels)
+		scrapeSettings := make(map[string]modules.ScrapeSettings)
+		ruleGroups := make(map[string][]rulefmt.RuleGroup)
+		var moduleRules []rulefmt.RuleGroup
 
-		for _, mod := range targetGroup.Modules.ModulesConfigs {
-			if !mod.IsEnabled() {
-				continue
-			}
-			m, err := mod.NewModule(modules.ModuleOptions{})
-			if err != nil {
-				return err
-			}
+		for _, mod := range enabledModuleConfigs(targetGroup) {
+			m := groupModules[i][mod.Name()]
+			tgs := gp.ModuleTargets[mod.Name()]
 			mtgs, err := m.GetTargets(tgs, targetGroup.Name)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			promTargets[mod.Name()] = append(promTargets[mod.Name()], mtgs...)
-			ruleGroups = append(ruleGroups, m.GetRules(targetGroup.Name))
-			dashboards = append(dashboards, m.GetDashboards()...)
+			scrapeSettings[mod.Name()] = targetGroup.Scrape
+			if sc, ok := mod.(modules.ScrapedConfig); ok {
+				scrapeSettings[mod.Name()] = targetGroup.Scrape.Merge(sc.ScrapeSettings())
+			}
+			rg := m.GetRules(targetGroup.Name)
+			moduleRules = append(moduleRules, rg)
+			rgs, err := groupModuleRules(targetGroup, rg, d.cfg.Global.ScopeModuleRules)
+			if err != nil {
+				return nil, err
+			}
+			ruleGroups[mod.Name()] = append(ruleGroups[mod.Name()], rgs...)
+			gp.RuleGroups = append(gp.RuleGroups, rgs...)
+			ds := m.GetDashboards()
+			for _, d := range ds {
+				global.Dashboards = append(global.Dashboards, d)
+			}
+			gp.Dashboards = append(gp.Dashboards, ds...)
 			if rp, ok := m.(modules.ReverseProxiedModule); ok {
 				newEntries, err := rp.ReverseProxy(tgs, targetGroup.Name)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				reverseProxyEntries = append(reverseProxyEntries, newEntries...)
+				global.ReverseProxyEntries = append(global.ReverseProxyEntries, newEntries...)
+				gp.ReverseProxyEntries = append(gp.ReverseProxyEntries, newEntries...)
 			}
 			if rp, ok := m.(modules.PrometheusModule); ok {
 				ps, err := rp.GetPrometheusServers(tgs, targetGroup.Name)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				promServers = append(promServers, ps...)
+				global.PrometheusServers = append(global.PrometheusServers, ps...)
 			}
 			if rp, ok := m.(modules.AlertmanagerModule); ok {
 				ps, err := rp.GetAlertmanagerServers(tgs, targetGroup.Name)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				amServers = append(amServers, ps...)
+				global.AlertmanagerServers = append(global.AlertmanagerServers, ps...)
 			}
+			if dp, ok := m.(modules.DatasourceProxyModule); ok {
+				proxies, err := dp.GetDatasourceProxies(tgs, targetGroup.Name)
+				if err != nil {
+					return nil, err
+				}
+				global.DatasourceProxies = append(global.DatasourceProxies, proxies...)
+			}
+		}
+		if unknown := targetGroup.AlertOverrides.Unknown(moduleRules); len(unknown) > 0 {
+			return nil, fmt.Errorf("target group %q: alert_overrides: unknown alerts %s", targetGroup.Name, strings.Join(unknown, ", "))
 		}
-		prometheusTargets[targetGroup.Name] = promTargets
-		c = ctx.SetPromRules(c, targetGroup.Name, ruleGroups)
+		custom, customFiles, err := targetGroupRules(targetGroup)
+		if err != nil {
+			return nil, err
+		}
+		ruleFiles := make(map[string][]string)
+		if len(custom) > 0 {
+			ruleGroups[targetGroupRulesKey] = custom
+			ruleFiles[targetGroupRulesKey] = customFiles
+			// The rules of the target group are checked against its own
+			// scrape settings.
+			scrapeSettings[targetGroupRulesKey] = targetGroup.Scrape
+			gp.RuleGroups = append(gp.RuleGroups, custom...)
+		}
+		gp.ScrapeTargets = promTargets
+		plan.Model.Groups = append(plan.Model.Groups, modules.GroupModel{
+			Name:           targetGroup.Name,
+			Targets:        tgs,
+			ModuleTargets:  gp.ModuleTargets,
+			ScrapeTargets:  promTargets,
+			ScrapeSettings: scrapeSettings,
+			RuleGroups:     ruleGroups,
+			RuleFiles:      ruleFiles,
+		})
 	}
 
-	c = ctx.SetPromServers(c, promServers)
-    c = ctx.SetAlertmanagerServers(c, amServers)
-	c = ctx.SetPromTargets(c, prometheusTargets)
-	c = ctx.SetDashboards(c, dashboards)
-	c = ctx.SetDashboardFiles(c, dashboardFiles)
-	c = ctx.SetReverseProxyEntries(c, reverseProxyEntries)
+	if err := validateRules(plan.Model.Groups); err != nil {
+		return nil, err
+	}
 
-	for _, targetGroup := range d.cfg.TargetGroups {
-		tgs, _ := moduleTargets[targetGroup.Name]
+	plan.Inventory = &ansiblemodel.Inventory{
+		Groups: make(map[string]ansiblemodel.Group),
+	}
+	for i, targetGroup := range d.cfg.TargetGroups {
+		gp := plan.Groups[i]
+		tgs := groupTargets[targetGroup.Name]
 
-		inventory, err := generateInventory(tgs)
-		if err != nil {
-			return err
-		}
+		addInventoryGroup(plan.Inventory, targetGroup.Name, tgs)
 
 		for _, mod := range targetGroup.Modules.ModulesConfigs {
-			for _, t := range tgs {
-				m, err := mod.NewModule(modules.ModuleOptions{})
-				if err != nil {
-					return err
-				}
+			m := groupModules[i][mod.Name()]
+			mtgs := tgs
+			if mod.IsEnabled() {
+				mtgs = gp.ModuleTargets[mod.Name()]
+				addInventoryGroup(plan.Inventory, moduleGroup(mod.Name()), mtgs)
+				addInventoryGroup(plan.Inventory, groupModuleGroup(targetGroup.Name, mod.Name()), mtgs)
+			}
+			for _, t := range mtgs {
 				vars, err := m.HostVars(t, targetGroup.Name)
 				if err != nil {
-					return err
+					return nil, err
 				}
-				addHostVars(inventory, t, vars)
+				addHostVars(plan.Inventory, targetGroup.Name, t, vars)
 			}
 		}
 
 		var pbs = make([]*ansiblemodel.Playbook, 0)
-		for _, mod := range targetGroup.Modules.ModulesConfigs {
-			if !mod.IsEnabled() {
-				continue
-			}
+		for _, mod := range sortModuleConfigs(enabledModuleConfigs(targetGroup), plan.order) {
 			if len(enabledModules) > 0 {
 				var found bool
 				for _, n := range enabledModules {
@@ -202,32 +374,51 @@ func (d *Deployer) Run(enabledModules, skipTags []string, limit string) error {
 					continue
 				}
 			}
-			m, err := mod.NewModule(modules.ModuleOptions{})
+			pb, err := groupModules[i][mod.Name()].Playbook(context.Background(), plan.Model)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			pb, err := m.Playbook(c)
-			if err != nil {
-				return err
+			if pb == nil {
+				continue
 			}
+			// Only run the play on the hosts of this target group that
+			// have the module enabled.
+			pb.Hosts = groupModuleGroup(targetGroup.Name, mod.Name())
+			gp.Modules = append(gp.Modules, mod.Name())
 			pbs = append(pbs, pb)
 		}
-		ar, err := ansible.NewRunner(d.logger, d.cfg, d.ansibleDebug, filepath.Join(d.homeDeps, "bin", "ansible-playbook"), d.homeDeps, inventory)
-		if err != nil {
-			return err
-		}
-		err = ar.RunPlaybooks(c, ansible.Ping(), skipTags, limit)
-		if err != nil {
-			return err
+		gp.Playbooks = pbs
+	}
+
+	return plan, nil
+}
+
+// newModules creates the modules of a target group, enabled or not, keyed by
+// module name. stores holds the store of each module, it is filled as needed.
+func (d *Deployer) newModules(targetGroup config.TargetGroup, stores map[string]*modules.Store, readOnly bool) (map[string]modules.Module, error) {
+	mods := make(map[string]modules.Module)
+	if targetGroup.Modules == nil {
+		return mods, nil
+	}
+	for _, mod := range targetGroup.Modules.ModulesConfigs {
+		store, ok := stores[mod.Name()]
+		if !ok {
+			store = modules.NewStore()
+			stores[mod.Name()] = store
 		}
-		err = ar.RunPlaybooks(c, pbs, skipTags, limit)
+		m, err := mod.NewModule(modules.ModuleOptions{
+			Logger:      log.With(d.logger, "module", mod.Name(), "target_group", targetGroup.Name),
+			DataDir:     d.cfg.Global.DataDir,
+			ReadOnly:    readOnly,
+			TargetGroup: targetGroup.Name,
+			Store:       store,
+		})
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not create module %q of target group %q: %w", mod.Name(), targetGroup.Name, err)
 		}
+		mods[mod.Name()] = m
 	}
-
-	level.Info(d.logger).Log("msg", "Deployment done")
-	return nil
+	return mods, nil
 }
 
 // validateConfig checks the Deployer's configuration for any issues.
@@ -240,38 +431,87 @@ func (d *Deployer) validateConfig() error {
 		return errors.New("configuration must have at least one target group")
 	}
 
+	errs := append(d.cfg.Validate(), validateDependencies(d.cfg)...)
+	// The static targets are known before the service discovery runs.
+	if err := validateTargets(d.cfg, staticModuleTargets(d.cfg)); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return fmt.Errorf("invalid configuration: %s", strings.Join(msgs, "; "))
+	}
+
 	return nil
 }
 
-// PopulateTargets populates targets
+// PopulateTargets runs the service discovery of the targets. It returns as
+// soon as every discoverer has sent its first update, or after syncTime,
+// whichever comes first. The discoverers that did not send any update in time
+// are logged.
 func PopulateTargets(logger log.Logger, targets *config.Targets, syncTime time.Duration) ([]*targetgroup.Group, error) {
-	targetGroupChan := make(chan []*targetgroup.Group)
-	level.Info(logger).Log("msg", "Waiting", "time", syncTime)
+	type update struct {
+		discoverer   int
+		targetGroups []*targetgroup.Group
+	}
+
+	updates := make(chan update)
+	level.Info(logger).Log("msg", "Waiting for service discovery", "max_time", syncTime)
 	ctx, cancel := context.WithTimeout(context.Background(), syncTime)
 	defer cancel()
 
-	for _, cfg := range targets.ServiceDiscoveryConfigs {
-		d, err := cfg.NewDiscoverer(discovery.DiscovererOptions{Logger: logger})
+	names := make([]string, len(targets.ServiceDiscoveryConfigs))
+	for i, cfg := range targets.ServiceDiscoveryConfigs {
+		names[i] = fmt.Sprintf("%s/%d", cfg.Name(), i)
+		d, err := cfg.NewDiscoverer(discovery.DiscovererOptions{Logger: log.With(logger, "discovery", names[i])})
 		if err != nil {
 			return nil, fmt.Errorf("could not create new discoverer: %w", err)
 		}
+		targetGroupChan := make(chan []*targetgroup.Group)
 		go d.Run(ctx, targetGroupChan)
+		go func(i int) {
+			for {
+				select {
+				case tgs := <-targetGroupChan:
+					select {
+					case updates <- update{discoverer: i, targetGroups: tgs}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i)
 	}
 
-	targetGroups := make([]*targetgroup.Group, 0)
+	ready := make([]bool, len(names))
+	pending := len(names)
 	targetGroupsMap := make(map[string]*targetgroup.Group)
 outerLoop:
-	for {
+	for pending > 0 {
 		select {
-		case targetGroups = <-targetGroupChan:
-			for _, tg := range targetGroups {
+		case u := <-updates:
+			for _, tg := range u.targetGroups {
 				targetGroupsMap[tg.Source] = tg
 			}
+			if !ready[u.discoverer] {
+				ready[u.discoverer] = true
+				pending--
+			}
 		case <-ctx.Done():
 			break outerLoop
 		}
 	}
 
+	for i, name := range names {
+		if !ready[i] {
+			level.Warn(logger).Log("msg", "Service discovery timed out before the first update", "discovery", name, "time", syncTime)
+		}
+	}
+
 	var targetGroupsOutput []*targetgroup.Group
 	for _, tgs := range targetGroupsMap {
 		targetGroupsOutput = append(targetGroupsOutput, tgs)
@@ -280,35 +520,47 @@ outerLoop:
 	return targetGroupsOutput, nil
 }
 
-func generateInventory(tgs []labels.Labels) (*ansiblemodel.Inventory, error) {
-	group := ansiblemodel.Group{
-		Hosts: map[string]ansiblemodel.Host{},
+// moduleGroup returns the name of the inventory group that contains the hosts
+// where a module is enabled.
+func moduleGroup(name string) string {
+	return name + "_module"
+}
+
+// groupModuleGroup returns the name of the inventory group that contains the
+// hosts of a target group that have a module enabled.
+func groupModuleGroup(group, name string) string {
+	return group + "_" + moduleGroup(name)
+}
+
+// addInventoryGroup adds the targets to the hosts of an inventory group,
+// creating the group if needed.
+func addInventoryGroup(inventory *ansiblemodel.Inventory, group string, tgs []labels.Labels) {
+	gr, ok := inventory.Groups[group]
+	if !ok {
+		gr = ansiblemodel.Group{
+			Hosts: map[string]ansiblemodel.Host{},
+		}
 	}
 	for _, tg := range tgs {
 		if tg.IsEmpty() {
 			continue
 		}
-		hostVars := map[string]interface{}{}
 		name := tg.Get(model.AddressLabel)
 		if name == "" {
 			continue
 		}
-
-		group.Hosts[name] = ansiblemodel.Host{
-			Variables: hostVars,
+		if _, ok := gr.Hosts[name]; ok {
+			continue
 		}
-	}
 
-	inventory := ansiblemodel.Inventory{
-		Groups: map[string]ansiblemodel.Group{
-			"all": group,
-		},
+		gr.Hosts[name] = ansiblemodel.Host{
+			Variables: map[string]interface{}{},
+		}
 	}
-
-	return &inventory, nil
+	inventory.Groups[group] = gr
 }
 
-func addHostVars(inventory *ansiblemodel.Inventory, tg labels.Labels, vars map[string]interface{}) error {
+func addHostVars(inventory *ansiblemodel.Inventory, group string, tg labels.Labels, vars map[string]interface{}) error {
 	if tg.IsEmpty() {
 		return nil
 	}
@@ -317,7 +569,7 @@ func addHostVars(inventory *ansiblemodel.Inventory, tg labels.Labels, vars map[s
 		return nil
 	}
 	for k, v := range vars {
-		inventory.Groups["all"].Hosts[name].Variables[k] = v
+		inventory.Groups[group].Hosts[name].Variables[k] = v
 	}
 	return nil
 }
@@ -0,0 +1,128 @@
+package deploy
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/roidelapluie/o11y-deploy/config"
+)
+
+func TestPlanDataDir(t *testing.T) {
+	dataDir := t.TempDir()
+	yamlString := fmt.Sprintf(`
+global:
+  data_directory: %s
+target_groups:
+  - name: servers
+    targets:
+      static_configs:
+      - targets: [host1:22]
+    modules:
+      prometheus_module:
+        enabled: true
+        file_sd: true
+      linux_module:
+        enabled: true
+      portal_module:
+        enabled: true
+`, dataDir)
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	// Written by the last deployment.
+	deployed := filepath.Join(dataDir, "rules", "servers_linux_0123456789abcdef.rules")
+	if err := os.MkdirAll(filepath.Dir(deployed), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deployed, []byte("groups: []"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := readTree(t, dataDir)
+	d := &Deployer{cfg: &c, logger: log.NewNopLogger()}
+	plan, err := d.Plan(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plan leaves the data directory as it is, but its playbooks refer
+	// to it, like the ones of a deployment.
+	if after := readTree(t, dataDir); !reflect.DeepEqual(before, after) {
+		t.Fatalf("expected the data directory to be unchanged, got %v, was %v", after, before)
+	}
+	gp := plan.Groups[0]
+	for i, mod := range gp.Modules {
+		if mod != "prometheus" {
+			continue
+		}
+		rulesFiles := gp.Playbooks[i].Vars["o11y_prometheus_rules_files"].([]string)
+		if len(rulesFiles) == 0 {
+			t.Fatal("expected rules files")
+		}
+		for _, f := range rulesFiles {
+			if filepath.Dir(f) != filepath.Join(dataDir, "rules") {
+				t.Fatalf("expected the rules files in the data directory, got %s", f)
+			}
+		}
+	}
+}
+
+func TestPlanMissingDataDir(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "data")
+	yamlString := fmt.Sprintf(`
+global:
+  data_directory: %s
+target_groups:
+  - name: servers
+    targets:
+      static_configs:
+      - targets: [host1:22]
+    modules:
+      portal_module:
+        enabled: true
+`, dataDir)
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	d := &Deployer{cfg: &c, logger: log.NewNopLogger()}
+	if _, err := d.Plan(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the data directory not to be created, got %v", err)
+	}
+}
+
+// readTree returns the contents of the files and directories under dir, keyed
+// by path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			tree[path] = "<dir>"
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		tree[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// moduleOrder returns the names of the modules enabled in any target group,
+// sorted so that every module comes after the modules it requires or must be
+// deployed after. Modules without constraints between them keep the order of
+// modules.Configs. It returns an error if the constraints contain a cycle.
+func moduleOrder(cfg *config.Config) ([]string, error) {
+	var names []string
+	known := make(map[string]bool)
+	// before maps module names to the modules that must be deployed before
+	// them.
+	before := make(map[string]map[string]bool)
+	for _, tg := range cfg.TargetGroups {
+		for _, mod := range enabledModuleConfigs(tg) {
+			name := mod.Name()
+			if !known[name] {
+				known[name] = true
+				names = append(names, name)
+				before[name] = make(map[string]bool)
+			}
+			if dc, ok := mod.(modules.DependentConfig); ok {
+				for _, dep := range append(dc.Requires(), dc.After()...) {
+					before[name][dep] = true
+				}
+			}
+		}
+	}
+
+	order := make([]string, 0, len(names))
+	done := make(map[string]bool)
+	for len(order) < len(names) {
+		progress := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for dep := range before[name] {
+				if known[dep] && !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[name] = true
+				order = append(order, name)
+				progress = true
+				// Start over so that modules keep their original order when
+				// possible.
+				break
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, name := range names {
+				if !done[name] {
+					cycle = append(cycle, name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between modules: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return order, nil
+}
+
+// sortModuleConfigs returns the module configs sorted in the given order.
+// Modules that are not part of order are left out.
+func sortModuleConfigs(mods []modules.Config, order []string) []modules.Config {
+	sorted := make([]modules.Config, 0, len(mods))
+	for _, name := range order {
+		for _, mod := range mods {
+			if mod.Name() == name {
+				sorted = append(sorted, mod)
+			}
+		}
+	}
+	return sorted
+}
@@ -0,0 +1,46 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/roidelapluie/o11y-deploy/config"
+	_ "github.com/roidelapluie/o11y-deploy/modules/alertmanager"
+	_ "github.com/roidelapluie/o11y-deploy/modules/grafana"
+	_ "github.com/roidelapluie/o11y-deploy/modules/linux"
+	_ "github.com/roidelapluie/o11y-deploy/modules/portal"
+	_ "github.com/roidelapluie/o11y-deploy/modules/prometheus"
+)
+
+func TestModuleOrder(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: portal
+    modules:
+      portal_module:
+        enabled: true
+      linux_module:
+        enabled: true
+  - name: monitoring
+    modules:
+      grafana_module:
+        enabled: true
+      prometheus_module:
+        enabled: true
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	order, err := moduleOrder(&c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"linux", "prometheus", "grafana", "portal"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected order %v, got %v", expected, order)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/roidelapluie/o11y-deploy/ansible"
+	ansiblemodel "github.com/roidelapluie/o11y-deploy/model/ansible"
+	"github.com/roidelapluie/o11y-deploy/util/writer"
+)
+
+// runGroups runs the playbooks module by module, in deployment order, so that
+// a module is deployed in every target group before the modules that depend on
+// it. The playbook of a module is run in its own ansible-playbook process per
+// target group, with at most parallel processes at a time. The output of each
+// process is prefixed with the name of the target group.
+//
+// Like Ansible does with the hosts, a target group that fails is left out of
+// the next modules, and the other target groups are still deployed.
+func (d *Deployer) runGroups(plan *Plan, ar *ansible.AnsibleRunner, skipTags []string, limit string, parallel int) error {
+	err := ar.RunPlaybooks(context.Background(), ansible.Ping(), skipTags, limit)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mtx    sync.Mutex
+		sem    = make(chan struct{}, parallel)
+		report = ansible.Report{}
+		failed = make([]bool, len(plan.Groups))
+	)
+	for _, module := range plan.order {
+		var wg sync.WaitGroup
+		for i, gp := range plan.Groups {
+			pb := gp.playbook(module)
+			if pb == nil || failed[i] {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, gp *GroupPlan) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				// The plays only target the hosts of their own target group.
+				out := writer.NewPrefixWriter(os.Stdout, fmt.Sprintf("[%s] ", gp.Name))
+				r, err := ar.RunPlaybooksTo(context.Background(), []*ansiblemodel.Playbook{pb}, skipTags, limit, out)
+				out.Flush()
+
+				mtx.Lock()
+				defer mtx.Unlock()
+				report.Merge(r)
+				if err != nil {
+					level.Error(d.logger).Log("msg", "Error deploying target group", "target_group", gp.Name, "module", module, "err", err)
+					failed[i] = true
+				}
+			}(i, gp)
+		}
+		// The next modules may depend on this one in any target group.
+		wg.Wait()
+	}
+
+	ansible.PrintReport(os.Stdout, report)
+
+	var failedGroups []string
+	for i, gp := range plan.Groups {
+		if failed[i] {
+			failedGroups = append(failedGroups, gp.Name)
+		}
+	}
+	if len(failedGroups) > 0 {
+		return fmt.Errorf("deployment failed for target groups: %s", strings.Join(failedGroups, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	ansiblemodel "github.com/roidelapluie/o11y-deploy/model/ansible"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// Plan is the rendered deployment, ready to be handed over to Ansible.
+type Plan struct {
+	Groups    []*GroupPlan
+	Inventory *ansiblemodel.Inventory
+
+	// Model is the data the playbooks were rendered from.
+	Model *modules.DeploymentModel
+
+	// order is the deployment order of the modules.
+	order []string
+}
+
+// GroupPlan is the rendered deployment of a single target group.
+type GroupPlan struct {
+	Name                string
+	Targets             []labels.Labels
+	ModuleTargets       map[string][]labels.Labels
+	Modules             []string
+	ScrapeTargets       map[string][]labels.Labels
+	RuleGroups          []rulefmt.RuleGroup
+	Dashboards          [][]byte
+	ReverseProxyEntries []modules.ReverseProxyEntry
+	Playbooks           []*ansiblemodel.Playbook
+}
+
+// Playbooks returns the playbooks of all the target groups, module by module
+// in deployment order, so that a module is deployed in every target group
+// before the modules that depend on it.
+func (p *Plan) Playbooks() []*ansiblemodel.Playbook {
+	var pbs []*ansiblemodel.Playbook
+	for _, name := range p.order {
+		for _, gp := range p.Groups {
+			if pb := gp.playbook(name); pb != nil {
+				pbs = append(pbs, pb)
+			}
+		}
+	}
+	return pbs
+}
+
+// playbook returns the playbook of a module, or nil if the module is not
+// deployed in the target group.
+func (gp *GroupPlan) playbook(module string) *ansiblemodel.Playbook {
+	for i, m := range gp.Modules {
+		if m == module {
+			return gp.Playbooks[i]
+		}
+	}
+	return nil
+}
+
+// Print writes a human readable summary of the plan to w.
+func (p *Plan) Print(w io.Writer) {
+	for i, gp := range p.Groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		gp.print(w)
+	}
+}
+
+func (gp *GroupPlan) print(w io.Writer) {
+	fmt.Fprintf(w, "Target group %q\n", gp.Name)
+
+	fmt.Fprintf(w, "  Hosts (%d):\n", len(gp.Targets))
+	for _, t := range gp.Targets {
+		fmt.Fprintf(w, "    %s %s\n", t.Get(model.AddressLabel), t.String())
+	}
+
+	mods := make([]string, 0, len(gp.Modules))
+	for _, m := range gp.Modules {
+		mods = append(mods, fmt.Sprintf("%s (%d/%d hosts)", m, len(gp.ModuleTargets[m]), len(gp.Targets)))
+	}
+	fmt.Fprintf(w, "  Modules: %s\n", strings.Join(mods, ", "))
+
+	fmt.Fprintf(w, "  Scrape jobs (%d):\n", len(gp.ScrapeTargets))
+	jobs := make([]string, 0, len(gp.ScrapeTargets))
+	for job := range gp.ScrapeTargets {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		addrs := make([]string, 0, len(gp.ScrapeTargets[job]))
+		for _, t := range gp.ScrapeTargets[job] {
+			addrs = append(addrs, t.Get(model.AddressLabel))
+		}
+		fmt.Fprintf(w, "    %s: %s\n", job, strings.Join(addrs, ", "))
+	}
+
+	fmt.Fprintf(w, "  Rule groups (%d):\n", len(gp.RuleGroups))
+	for _, rg := range gp.RuleGroups {
+		names := make([]string, 0, len(rg.Rules))
+		for _, r := range rg.Rules {
+			if r.Alert.Value != "" {
+				names = append(names, r.Alert.Value)
+			} else {
+				names = append(names, r.Record.Value)
+			}
+		}
+		fmt.Fprintf(w, "    %s (%d rules)", rg.Name, len(rg.Rules))
+		if len(names) > 0 {
+			fmt.Fprintf(w, ": %s", strings.Join(names, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "  Dashboards (%d):\n", len(gp.Dashboards))
+	for _, d := range gp.Dashboards {
+		fmt.Fprintf(w, "    %s\n", dashboardTitle(d))
+	}
+
+	fmt.Fprintf(w, "  Reverse proxy entries (%d):\n", len(gp.ReverseProxyEntries))
+	for _, e := range gp.ReverseProxyEntries {
+		fmt.Fprintf(w, "    %s %s -> %s\n", e.Name, e.Prefix, e.URL)
+	}
+
+	fmt.Fprintf(w, "  Playbooks (%d):\n", len(gp.Playbooks))
+	for _, pb := range gp.Playbooks {
+		roles := make([]string, 0, len(pb.Roles))
+		for _, r := range pb.Roles {
+			roles = append(roles, r.Name)
+		}
+		fmt.Fprintf(w, "    %s (hosts: %s, roles: %s)\n", pb.Name, pb.Hosts, strings.Join(roles, ", "))
+	}
+}
+
+func dashboardTitle(d []byte) string {
+	var dashboard struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(d, &dashboard); err != nil || dashboard.Title == "" {
+		return "(untitled)"
+	}
+	return dashboard.Title
+}
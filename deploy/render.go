@@ -0,0 +1,66 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/roidelapluie/o11y-deploy/ansible"
+)
+
+// Render writes the deployment to dir as a standalone Ansible project: an
+// ansible.cfg, the roles, the Prometheus rules files, an inventory and a
+// playbook that can be run with ansible-playbook.
+func (d *Deployer) Render(enabledModules []string, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(absDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("output directory %q is not empty", absDir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// The project can be moved: its playbook refers to the files next to
+	// it.
+	plan, err := d.plan(enabledModules, absDir, "{{ playbook_dir }}", true)
+	if err != nil {
+		return err
+	}
+
+	if err := ansible.ExportRoles(absDir); err != nil {
+		return err
+	}
+
+	if err := ansible.Export(d.logger, d.cfg, absDir, plan.Inventory, append(ansible.Ping(), plan.Playbooks()...)); err != nil {
+		return err
+	}
+
+	model, err := json.MarshalIndent(plan.Model, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal deployment model: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(absDir, "model.json"), model, 0644); err != nil {
+		return err
+	}
+
+	level.Info(d.logger).Log("msg", "Deployment rendered", "path", absDir)
+	return nil
+}
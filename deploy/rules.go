@@ -0,0 +1,57 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+// targetGroupRulesKey is the key of the rules of the target group itself,
+// in the rule groups by module of the deployment model.
+const targetGroupRulesKey = "custom"
+
+// targetGroupRules returns the rules of the target group itself, scoped to
+// the target group, and the files they were read from.
+func targetGroupRules(tg config.TargetGroup) ([]rulefmt.RuleGroup, []string, error) {
+	rgs, files, err := tg.LoadRules()
+	if err != nil {
+		return nil, nil, fmt.Errorf("target group %q: %w", tg.Name, err)
+	}
+	scoped, err := rules.Scope(rgs, "group_name", tg.Name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("target group %q: %w", tg.Name, err)
+	}
+	return scoped, files, nil
+}
+
+// groupModuleRules returns the rules of a module for a target group, with the
+// alert overrides of the target group. If scope is set, the rules are scoped
+// to the target group: without the scope, the rules of every target group
+// evaluate the series of the others, and an override does not prevent the
+// alerts of the other target groups.
+func groupModuleRules(tg config.TargetGroup, rg rulefmt.RuleGroup, scope bool) ([]rulefmt.RuleGroup, error) {
+	rgs := tg.AlertOverrides.Apply([]rulefmt.RuleGroup{rg})
+	if !scope {
+		return rgs, nil
+	}
+	scoped, err := rules.Scope(rgs, "group_name", tg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("target group %q: %w", tg.Name, err)
+	}
+	return scoped, nil
+}
@@ -0,0 +1,133 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/modules"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+func TestGroupModuleRulesOverrides(t *testing.T) {
+	yamlString := `
+global:
+  scope_module_rules: true
+target_groups:
+  - name: a
+    modules:
+      linux_module:
+        enabled: true
+    alert_overrides:
+      HostOutOfMemory:
+        disabled: true
+  - name: b
+    modules:
+      linux_module:
+        enabled: true
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	d := &Deployer{cfg: &c, logger: log.NewNopLogger()}
+
+	var all []rulefmt.RuleGroup
+	stores := make(map[string]*modules.Store)
+	for _, tg := range c.TargetGroups {
+		mods, err := d.newModules(tg, stores, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgs, err := groupModuleRules(tg, mods["linux"].GetRules(tg.Name), c.Global.ScopeModuleRules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, rgs...)
+	}
+
+	// Both hosts are out of memory, only the host of the target group b
+	// alerts.
+	tf, err := rules.ParseTestFile([]byte(`
+tests:
+- interval: 1m
+  input_series:
+  - series: 'node_memory_MemAvailable_bytes{group_name="a", instance="host1:9100", job="linux"}'
+    values: '512x5'
+  - series: 'node_memory_MemTotal_bytes{group_name="a", instance="host1:9100", job="linux"}'
+    values: '8192x5'
+  - series: 'node_uname_info{group_name="a", instance="host1:9100", job="linux", nodename="host1"}'
+    values: '1x5'
+  - series: 'node_memory_MemAvailable_bytes{group_name="b", instance="host2:9100", job="linux"}'
+    values: '512x5'
+  - series: 'node_memory_MemTotal_bytes{group_name="b", instance="host2:9100", job="linux"}'
+    values: '8192x5'
+  - series: 'node_uname_info{group_name="b", instance="host2:9100", job="linux", nodename="host2"}'
+    values: '1x5'
+  alert_rule_test:
+  - eval_time: 5m
+    alertname: HostOutOfMemory
+    exp_alerts:
+    - exp_labels:
+        severity: warning
+        group_name: b
+        instance: host2:9100
+        job: linux
+        nodename: host2
+      exp_annotations:
+        summary: Host out of memory (instance host2:9100)
+        description: "Node memory is filling up (< 10% left)\n  VALUE = 6.25\n  LABELS = map[group_name:b instance:host2:9100 job:linux nodename:host2]"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range tf.Run(all) {
+		t.Error(err)
+	}
+}
+
+func TestGroupModuleRulesUnscoped(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: a
+    modules:
+      linux_module:
+        enabled: true
+    alert_overrides:
+      HostOutOfMemory:
+        for: 5m
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	d := &Deployer{cfg: &c, logger: log.NewNopLogger()}
+	tg := c.TargetGroups[0]
+	mods, err := d.newModules(tg, make(map[string]*modules.Store), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rg := mods["linux"].GetRules(tg.Name)
+	rgs, err := groupModuleRules(tg, rg, c.Global.ScopeModuleRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the override is applied, the rules keep their expressions and
+	// labels.
+	r := rgs[0].Rules[0]
+	if r.Expr.Value != rg.Rules[0].Expr.Value {
+		t.Fatalf("expected the expression %q, got %q", rg.Rules[0].Expr.Value, r.Expr.Value)
+	}
+	if _, ok := r.Labels["group_name"]; ok {
+		t.Fatalf("unexpected group_name label in %v", r.Labels)
+	}
+	if r.For != model.Duration(5*time.Minute) {
+		t.Fatalf("expected the overridden for, got %v", r.For)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+const stateFile = "state.yml"
+
+// State is a snapshot of every rendered artifact of a deployment. It is
+// saved in the data directory after each successful deployment.
+type State struct {
+	Inventory interface{}  `yaml:"inventory"`
+	Groups    []GroupState `yaml:"target_groups"`
+}
+
+// GroupState is the snapshot of a single target group.
+type GroupState struct {
+	Name                string                 `yaml:"name"`
+	ScrapeTargets       map[string]interface{} `yaml:"scrape_targets"`
+	Playbooks           map[string]interface{} `yaml:"playbooks"`
+	Rules               string                 `yaml:"rules"`
+	Dashboards          map[string]interface{} `yaml:"dashboards"`
+	ReverseProxyEntries interface{}            `yaml:"reverse_proxy_entries"`
+}
+
+// State returns the snapshot of the plan.
+func (p *Plan) State() (*State, error) {
+	s := State{
+		Inventory: p.Inventory,
+	}
+	for _, gp := range p.Groups {
+		gs := GroupState{
+			Name:                gp.Name,
+			ScrapeTargets:       make(map[string]interface{}, len(gp.ScrapeTargets)),
+			Playbooks:           make(map[string]interface{}, len(gp.Playbooks)),
+			Dashboards:          make(map[string]interface{}, len(gp.Dashboards)),
+			ReverseProxyEntries: gp.ReverseProxyEntries,
+		}
+
+		for job, targets := range gp.ScrapeTargets {
+			jobTargets := make(map[string]map[string]string, len(targets))
+			for _, t := range targets {
+				jobTargets[t.Get(model.AddressLabel)] = t.Map()
+			}
+			gs.ScrapeTargets[job] = jobTargets
+		}
+
+		for i, pb := range gp.Playbooks {
+			gs.Playbooks[gp.Modules[i]] = pb.Vars
+		}
+
+		rules, err := yaml.Marshal(rulefmt.RuleGroups{Groups: gp.RuleGroups})
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal rules of target group %q: %w", gp.Name, err)
+		}
+		gs.Rules = string(rules)
+
+		for i, d := range gp.Dashboards {
+			var dashboard interface{}
+			if err := json.Unmarshal(d, &dashboard); err != nil {
+				return nil, fmt.Errorf("could not unmarshal dashboard of target group %q: %w", gp.Name, err)
+			}
+			key := dashboardKey(d, i)
+			if _, ok := gs.Dashboards[key]; ok {
+				key = dashboardIndexKey(d, i)
+			}
+			gs.Dashboards[key] = dashboard
+		}
+
+		s.Groups = append(s.Groups, gs)
+	}
+
+	// Go through YAML so that the snapshot compares equal to one loaded from
+	// disk.
+	data, err := yaml.Marshal(&s)
+	if err != nil {
+		return nil, err
+	}
+	var normalized State
+	if err := yaml.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return &normalized, nil
+}
+
+// dashboardKey returns the key of a dashboard in the snapshot: its uid, or
+// its position and title if it has none. The titles are not unique.
+func dashboardKey(d []byte, i int) string {
+	var dashboard struct {
+		UID string `json:"uid"`
+	}
+	if err := json.Unmarshal(d, &dashboard); err != nil || dashboard.UID == "" {
+		return dashboardIndexKey(d, i)
+	}
+	return dashboard.UID
+}
+
+func dashboardIndexKey(d []byte, i int) string {
+	return fmt.Sprintf("#%d %s", i, dashboardTitle(d))
+}
+
+// LoadState reads the snapshot of the last deployment from dataDir. It
+// returns an empty State if there is none.
+func LoadState(dataDir string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, stateFile))
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("could not read deployment state: %w", err)
+	}
+	return &s, nil
+}
+
+// Save writes the snapshot to dataDir.
+func (s *State) Save(dataDir string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, stateFile), data, 0600)
+}
+
+// Modules returns a copy of the snapshot with only the playbooks of the given
+// modules, as planned when the deployment is limited to them. The other
+// artifacts are always planned for every module.
+func (s *State) Modules(names []string) *State {
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n] = true
+	}
+	filtered := State{
+		Inventory: s.Inventory,
+		Groups:    make([]GroupState, 0, len(s.Groups)),
+	}
+	for _, g := range s.Groups {
+		playbooks := make(map[string]interface{}, len(g.Playbooks))
+		for name, vars := range g.Playbooks {
+			if keep[name] {
+				playbooks[name] = vars
+			}
+		}
+		g.Playbooks = playbooks
+		filtered.Groups = append(filtered.Groups, g)
+	}
+	return &filtered
+}
+
+func (s *State) group(name string) *GroupState {
+	for i := range s.Groups {
+		if s.Groups[i].Name == name {
+			return &s.Groups[i]
+		}
+	}
+	return nil
+}
+
+// DiffStates writes the differences between the deployed and the current
+// snapshot to w, per target group. It returns false if there are none.
+func DiffStates(w io.Writer, deployed, current *State) bool {
+	var changed bool
+	if diff := cmp.Diff(deployed.Inventory, current.Inventory); diff != "" {
+		fmt.Fprintf(w, "Inventory (-deployed +current):\n%s\n", diff)
+		changed = true
+	}
+	for i := range current.Groups {
+		cur := &current.Groups[i]
+		dep := deployed.group(cur.Name)
+		if dep == nil {
+			fmt.Fprintf(w, "Target group %q: added\n", cur.Name)
+			dep = &GroupState{}
+		}
+		if diffGroupStates(w, dep, cur) {
+			changed = true
+		}
+	}
+	for _, dep := range deployed.Groups {
+		if current.group(dep.Name) == nil {
+			fmt.Fprintf(w, "Target group %q: removed\n", dep.Name)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func diffGroupStates(w io.Writer, deployed, current *GroupState) bool {
+	sections := []struct {
+		name              string
+		deployed, current interface{}
+	}{
+		{"scrape targets", deployed.ScrapeTargets, current.ScrapeTargets},
+		{"playbooks", deployed.Playbooks, current.Playbooks},
+		{"rules", deployed.Rules, current.Rules},
+		{"dashboards", deployed.Dashboards, current.Dashboards},
+		{"reverse proxy entries", deployed.ReverseProxyEntries, current.ReverseProxyEntries},
+	}
+
+	var changed bool
+	for _, section := range sections {
+		diff := cmp.Diff(section.deployed, section.current)
+		if diff == "" {
+			continue
+		}
+		if !changed {
+			fmt.Fprintf(w, "Target group %q:\n", current.Name)
+			changed = true
+		}
+		fmt.Fprintf(w, "  %s (-deployed +current):\n%s\n", section.name, diff)
+	}
+	return changed
+}
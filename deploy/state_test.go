@@ -0,0 +1,146 @@
+package deploy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	ansiblemodel "github.com/roidelapluie/o11y-deploy/model/ansible"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+func testPlan(target string) *Plan {
+	return &Plan{
+		Inventory: &ansiblemodel.Inventory{
+			Groups: map[string]ansiblemodel.Group{
+				"servers": {Hosts: map[string]ansiblemodel.Host{target + ":22": {}}},
+			},
+		},
+		Groups: []*GroupPlan{
+			{
+				Name:    "servers",
+				Modules: []string{"linux"},
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", target+":9100", "group_name", "servers")},
+				},
+				RuleGroups: []rulefmt.RuleGroup{{Name: "servers-linux"}},
+				ReverseProxyEntries: []modules.ReverseProxyEntry{
+					{Name: "linux", URL: "http://" + target + ":9100", Prefix: "/linux/", Host: target},
+				},
+				Playbooks: []*ansiblemodel.Playbook{
+					{Name: "Linux", Vars: map[string]interface{}{"node_exporter_version": "1.5.0"}},
+				},
+			},
+		},
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	deployed, err := testPlan("host1").State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deployed.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if DiffStates(&buf, loaded, deployed) {
+		t.Fatalf("expected no diff, got:\n%s", buf.String())
+	}
+
+	current, err := testPlan("host2").State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !DiffStates(&buf, loaded, current) {
+		t.Fatal("expected a diff")
+	}
+	if !strings.Contains(buf.String(), "Inventory (-deployed +current)") {
+		t.Errorf("expected a diff of the inventory, got:\n%s", buf.String())
+	}
+	for _, section := range []string{"scrape targets", "reverse proxy entries"} {
+		if !strings.Contains(buf.String(), "  "+section+" (-deployed +current)") {
+			t.Errorf("expected a diff of the %s, got:\n%s", section, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "playbooks") {
+		t.Errorf("expected no diff of the playbooks, got:\n%s", buf.String())
+	}
+}
+
+func TestStateModules(t *testing.T) {
+	plan := testPlan("host1")
+	plan.Groups[0].Modules = append(plan.Groups[0].Modules, "grafana")
+	plan.Groups[0].Playbooks = append(plan.Groups[0].Playbooks, &ansiblemodel.Playbook{
+		Name: "Grafana", Vars: map[string]interface{}{"grafana_version": "10.4.1"},
+	})
+	deployed, err := plan.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Planned with only the linux module.
+	current, err := testPlan("host1").State()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if !DiffStates(&buf, deployed, current) {
+		t.Fatal("expected the grafana playbook to be removed from the full state")
+	}
+	buf.Reset()
+	if DiffStates(&buf, deployed.Modules([]string{"linux"}), current) {
+		t.Fatalf("expected no diff, got:\n%s", buf.String())
+	}
+	if len(deployed.Groups[0].Playbooks) != 2 {
+		t.Fatal("expected the state not to be modified")
+	}
+}
+
+// testDashboards are dashboards with the same title, or without title.
+func testDashboards() [][]byte {
+	return [][]byte{
+		[]byte(`{"uid": "a", "title": "Linux", "version": 1}`),
+		[]byte(`{"uid": "b", "title": "Linux", "version": 1}`),
+		[]byte(`{"title": "Linux", "version": 1}`),
+		[]byte(`{"version": 1}`),
+		[]byte(`{"version": 1}`),
+	}
+}
+
+func TestStateDashboards(t *testing.T) {
+	plan := testPlan("host1")
+	plan.Groups[0].Dashboards = testDashboards()
+	deployed, err := plan.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployed.Groups[0].Dashboards) != 5 {
+		t.Fatalf("expected 5 dashboards, got %v", deployed.Groups[0].Dashboards)
+	}
+
+	// A change in any of the dashboards is a difference.
+	for i := range plan.Groups[0].Dashboards {
+		plan := testPlan("host1")
+		plan.Groups[0].Dashboards = testDashboards()
+		plan.Groups[0].Dashboards[i] = bytes.Replace(plan.Groups[0].Dashboards[i], []byte(`"version": 1`), []byte(`"version": 2`), 1)
+		current, err := plan.State()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if !DiffStates(&buf, deployed, current) {
+			t.Errorf("expected a diff of dashboard %d", i)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// DroppedTarget is a discovered target that was dropped by relabeling.
+type DroppedTarget struct {
+	// Labels are the labels of the target, as discovered.
+	Labels labels.Labels
+	// Rule is the index of the relabel config that dropped the target.
+	Rule int
+}
+
+// relabelTargets applies the relabel configs to the discovered targets. It
+// returns the targets that are kept, and the ones that are dropped.
+func relabelTargets(targets []*targetgroup.Group, cfgs []*relabel.Config) ([]labels.Labels, []DroppedTarget) {
+	kept := make([]labels.Labels, 0)
+	var dropped []DroppedTarget
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for _, t := range targets {
+		for _, tg := range t.Targets {
+			lb.Reset(labels.EmptyLabels())
+
+			for ln, lv := range tg {
+				lb.Set(string(ln), string(lv))
+			}
+			for ln, lv := range t.Labels {
+				if _, ok := tg[ln]; !ok {
+					lb.Set(string(ln), string(lv))
+				}
+			}
+			discovered := lb.Labels(labels.EmptyLabels())
+
+			// Apply the relabel configs one by one, to know which one
+			// drops the target.
+			relabeled, keep := discovered, true
+			for i, cfg := range cfgs {
+				if relabeled, keep = relabel.Process(relabeled, cfg); !keep {
+					dropped = append(dropped, DroppedTarget{Labels: discovered, Rule: i})
+					break
+				}
+			}
+			if keep {
+				kept = append(kept, relabeled)
+			}
+		}
+	}
+	return kept, dropped
+}
+
+// Targets runs the service discovery and the relabeling of every target
+// group, and writes the resulting targets to w, with the addresses that each
+// module would scrape.
+func (d *Deployer) Targets(w io.Writer) error {
+	if err := d.validateConfig(); err != nil {
+		return err
+	}
+
+	stores := make(map[string]*modules.Store)
+	for i, targetGroup := range d.cfg.TargetGroups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		targets, err := PopulateTargets(d.logger, targetGroup.Targets, time.Duration(d.cfg.Global.SDSyncTime))
+		if err != nil {
+			return err
+		}
+		kept, dropped := relabelTargets(targets, targetGroup.Targets.RelabelConfigs)
+
+		groupModules, err := d.newModules(targetGroup, stores, true)
+		if err != nil {
+			return err
+		}
+		var mods []modules.Module
+		var names []string
+		for _, mod := range enabledModuleConfigs(targetGroup) {
+			mods = append(mods, groupModules[mod.Name()])
+			names = append(names, mod.Name())
+		}
+
+		fmt.Fprintf(w, "Target group %q\n", targetGroup.Name)
+		fmt.Fprintf(w, "  Targets (%d):\n", len(kept))
+		for _, t := range kept {
+			fmt.Fprintf(w, "    %s\n", t.String())
+			for j, m := range mods {
+				if _, keep := relabel.Process(t, targetGroup.Targets.ModuleRelabelConfigs[names[j]]...); !keep {
+					fmt.Fprintf(w, "      %s: (not selected by module_relabel_configs)\n", names[j])
+					continue
+				}
+				mtgs, err := m.GetTargets([]labels.Labels{t}, targetGroup.Name)
+				if err != nil {
+					return fmt.Errorf("target group %q: module %q: %w", targetGroup.Name, names[j], err)
+				}
+				addrs := make([]string, 0, len(mtgs))
+				for _, mt := range mtgs {
+					addrs = append(addrs, mt.Get(model.AddressLabel))
+				}
+				if len(addrs) == 0 {
+					addrs = append(addrs, "(not scraped)")
+				}
+				fmt.Fprintf(w, "      %s: %s\n", names[j], strings.Join(addrs, ", "))
+			}
+		}
+
+		fmt.Fprintf(w, "  Dropped targets (%d):\n", len(dropped))
+		for _, t := range dropped {
+			cfg := targetGroup.Targets.RelabelConfigs[t.Rule]
+			fmt.Fprintf(w, "    %s\n", t.Labels.String())
+			fmt.Fprintf(w, "      dropped by relabel_configs[%d] (action: %s, source_labels: %v, regex: %s)\n", t.Rule, cfg.Action, cfg.SourceLabels, cfg.Regex.String())
+		}
+	}
+
+	return nil
+}
+
+// selectModuleTargets returns the targets that a module is enabled on: the
+// targets kept by the module relabel configs, unchanged. Without relabel
+// configs, the module is enabled on every target.
+func selectModuleTargets(targets []labels.Labels, cfgs []*relabel.Config) []labels.Labels {
+	if len(cfgs) == 0 {
+		return targets
+	}
+	selected := make([]labels.Labels, 0, len(targets))
+	for _, t := range targets {
+		if _, keep := relabel.Process(t, cfgs...); keep {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// moduleTargets returns the targets of each enabled module of a target group,
+// by module name.
+func moduleTargets(targetGroup config.TargetGroup, targets []labels.Labels) map[string][]labels.Labels {
+	mt := make(map[string][]labels.Labels)
+	for _, mod := range enabledModuleConfigs(targetGroup) {
+		var cfgs []*relabel.Config
+		if targetGroup.Targets != nil {
+			cfgs = targetGroup.Targets.ModuleRelabelConfigs[mod.Name()]
+		}
+		mt[mod.Name()] = selectModuleTargets(targets, cfgs)
+	}
+	return mt
+}
+
+// staticModuleTargets returns the targets of each enabled module, by target
+// group, that are known without running the service discovery: the targets of
+// the static_configs, once relabeled.
+func staticModuleTargets(cfg *config.Config) map[string]map[string][]labels.Labels {
+	gmt := make(map[string]map[string][]labels.Labels)
+	for _, tg := range cfg.TargetGroups {
+		var (
+			static []*targetgroup.Group
+			cfgs   []*relabel.Config
+		)
+		if tg.Targets != nil {
+			for _, sd := range tg.Targets.ServiceDiscoveryConfigs {
+				if sc, ok := sd.(discovery.StaticConfig); ok {
+					static = append(static, sc...)
+				}
+			}
+			cfgs = tg.Targets.RelabelConfigs
+		}
+		tgs, _ := relabelTargets(static, cfgs)
+		gmt[tg.Name] = moduleTargets(tg, tgs)
+	}
+	return gmt
+}
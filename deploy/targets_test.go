@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+func TestRelabelTargets(t *testing.T) {
+	targets := []*targetgroup.Group{
+		{
+			Targets: []model.LabelSet{
+				{model.AddressLabel: "host1:22"},
+				{model.AddressLabel: "host2:22"},
+				{model.AddressLabel: "host3:22", "env": "test"},
+			},
+			Labels: model.LabelSet{"env": "prod"},
+		},
+	}
+	cfgs := []*relabel.Config{
+		{
+			SourceLabels: model.LabelNames{"env"},
+			Regex:        relabel.MustNewRegexp("test"),
+			Action:       relabel.Drop,
+		},
+		{
+			SourceLabels: model.LabelNames{model.AddressLabel},
+			Regex:        relabel.MustNewRegexp("host2.*"),
+			Action:       relabel.Drop,
+		},
+	}
+
+	kept, dropped := relabelTargets(targets, cfgs)
+
+	if len(kept) != 1 || kept[0].Get(model.AddressLabel) != "host1:22" || kept[0].Get("env") != "prod" {
+		t.Fatalf("unexpected kept targets: %v", kept)
+	}
+	if len(dropped) != 2 {
+		t.Fatalf("expected 2 dropped targets, got %v", dropped)
+	}
+	for _, d := range dropped {
+		switch d.Labels.Get(model.AddressLabel) {
+		case "host2:22":
+			if d.Rule != 1 {
+				t.Errorf("expected host2 to be dropped by rule 1, got %d", d.Rule)
+			}
+		case "host3:22":
+			if d.Rule != 0 {
+				t.Errorf("expected host3 to be dropped by rule 0, got %d", d.Rule)
+			}
+		default:
+			t.Errorf("unexpected dropped target: %v", d.Labels)
+		}
+	}
+}
+
+func TestSelectModuleTargets(t *testing.T) {
+	targets := []labels.Labels{
+		labels.FromStrings(model.AddressLabel, "host1:22", "o11y_role", "prometheus"),
+		labels.FromStrings(model.AddressLabel, "host2:22"),
+	}
+	cfgs := []*relabel.Config{
+		{
+			SourceLabels: model.LabelNames{"o11y_role"},
+			Regex:        relabel.MustNewRegexp("prometheus"),
+			Action:       relabel.Keep,
+		},
+		{
+			TargetLabel: "o11y_role",
+			Replacement: "changed",
+			Regex:       relabel.MustNewRegexp("(.*)"),
+			Action:      relabel.Replace,
+		},
+	}
+
+	selected := selectModuleTargets(targets, cfgs)
+	if len(selected) != 1 || !labels.Equal(selected[0], targets[0]) {
+		t.Fatalf("unexpected selected targets: %v", selected)
+	}
+	if selected := selectModuleTargets(targets, nil); len(selected) != 2 {
+		t.Fatalf("expected all targets without relabel configs, got %v", selected)
+	}
+}
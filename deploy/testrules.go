@@ -0,0 +1,115 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/modules"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+// TestRules runs the rules unit tests of the modules, and the given unit
+// tests files against all the rules of each target group, or only of
+// targetGroup if it is not empty. The results are written to w.
+func (d *Deployer) TestRules(w io.Writer, files []string, targetGroup string) error {
+	if err := d.validateConfig(); err != nil {
+		return err
+	}
+
+	testFiles := make([]*rules.TestFile, 0, len(files))
+	for _, f := range files {
+		tf, err := rules.LoadTestFile(f)
+		if err != nil {
+			return err
+		}
+		testFiles = append(testFiles, tf)
+	}
+
+	var found, failed bool
+	stores := make(map[string]*modules.Store)
+	for _, tg := range d.cfg.TargetGroups {
+		if targetGroup != "" && tg.Name != targetGroup {
+			continue
+		}
+		found = true
+
+		groupModules, err := d.newModules(tg, stores, true)
+		if err != nil {
+			return err
+		}
+		var all []rulefmt.RuleGroup
+		for _, mod := range enabledModuleConfigs(tg) {
+			m := groupModules[mod.Name()]
+			rg := m.GetRules(tg.Name)
+			rgs, err := groupModuleRules(tg, rg, d.cfg.Global.ScopeModuleRules)
+			if err != nil {
+				return err
+			}
+			all = append(all, rgs...)
+
+			rt, ok := m.(modules.RulesTestedModule)
+			if !ok {
+				continue
+			}
+			// The tests of a module expect its rules as it defines them,
+			// without the overrides and the scope of the target group.
+			tf, err := rules.ParseTestFile(rt.RulesTests())
+			if err != nil {
+				return fmt.Errorf("module %q: could not parse rules tests: %w", mod.Name(), err)
+			}
+			if !reportRulesTests(w, fmt.Sprintf("target group %q, module %q", tg.Name, mod.Name()), tf.Run([]rulefmt.RuleGroup{rg})) {
+				failed = true
+			}
+		}
+
+		custom, _, err := targetGroupRules(tg)
+		if err != nil {
+			return err
+		}
+		all = append(all, custom...)
+
+		for i, tf := range testFiles {
+			if !reportRulesTests(w, fmt.Sprintf("target group %q, %s", tg.Name, files[i]), tf.Run(all)) {
+				failed = true
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("unknown target group %q", targetGroup)
+	}
+	if failed {
+		return errors.New("rules unit tests failed")
+	}
+	return nil
+}
+
+// reportRulesTests writes the result of rules unit tests to w. It returns
+// false if the tests failed.
+func reportRulesTests(w io.Writer, name string, errs []error) bool {
+	fmt.Fprintf(w, "Unit testing %s\n", name)
+	if len(errs) == 0 {
+		fmt.Fprintln(w, "  SUCCESS")
+		return true
+	}
+	fmt.Fprintln(w, "  FAILED:")
+	for _, err := range errs {
+		fmt.Fprintf(w, "    %v\n", err)
+	}
+	return false
+}
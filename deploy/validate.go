@@ -0,0 +1,208 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deploy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/modules"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+// validateDependencies checks that the modules every enabled module requires
+// are enabled somewhere in the configuration, and that the modules can be
+// ordered.
+func validateDependencies(cfg *config.Config) []error {
+	enabled := make(map[string]bool)
+	for _, tg := range cfg.TargetGroups {
+		for _, mod := range enabledModuleConfigs(tg) {
+			enabled[mod.Name()] = true
+		}
+	}
+
+	var errs []error
+	for _, tg := range cfg.TargetGroups {
+		for _, mod := range enabledModuleConfigs(tg) {
+			dc, ok := mod.(modules.DependentConfig)
+			if !ok {
+				continue
+			}
+			for _, dep := range dc.Requires() {
+				if !enabled[dep] {
+					errs = append(errs, fmt.Errorf("target group %q: module %q requires module %q to be enabled in a target group", tg.Name, mod.Name(), dep))
+				}
+			}
+		}
+	}
+	if _, err := moduleOrder(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// hostModule is a module enabled on a host by a target group.
+type hostModule struct {
+	group  string
+	module modules.Config
+}
+
+// validateTargets checks that the target groups do not conflict with each
+// other once their targets are resolved: a singleton module can only be
+// enabled once per host, and two different modules can not listen on the same
+// port of a host. The ports that are only used with highly available
+// Prometheus clusters are only checked when there are such clusters. The other
+// modules, like linux, can be enabled by several target groups on the same
+// host. groupModuleTargets maps target group names to the targets of each of
+// their modules.
+func validateTargets(cfg *config.Config, groupModuleTargets map[string]map[string][]labels.Labels) error {
+	hosts := make(map[string][]hostModule)
+	var clustered bool
+	for _, tg := range cfg.TargetGroups {
+		for _, mod := range enabledModuleConfigs(tg) {
+			if c, ok := mod.(modules.ClusterConfig); ok && c.HighlyAvailable() && len(groupModuleTargets[tg.Name][mod.Name()]) > 0 {
+				clustered = true
+			}
+			for _, t := range groupModuleTargets[tg.Name][mod.Name()] {
+				host := targetHost(t)
+				hosts[host] = append(hosts[host], hostModule{group: tg.Name, module: mod})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, host := range names {
+		singletons := make(map[string]string)
+		ports := make(map[string]hostModule)
+		for _, hm := range hosts[host] {
+			name := hm.module.Name()
+			if s, ok := hm.module.(modules.SingletonConfig); ok && s.Singleton() {
+				if group, ok := singletons[name]; ok && group != hm.group {
+					msgs = append(msgs, fmt.Sprintf("host %q: module %q is enabled by target groups %q and %q", host, name, group, hm.group))
+				} else {
+					singletons[name] = hm.group
+				}
+			}
+			p, ok := hm.module.(modules.PortsConfig)
+			if !ok {
+				continue
+			}
+			modPorts := p.Ports()
+			if cp, ok := p.(modules.ClusterPortsConfig); ok && clustered {
+				modPorts = append(modPorts, cp.ClusterPorts()...)
+			}
+			for _, port := range modPorts {
+				other, ok := ports[port]
+				if !ok {
+					ports[port] = hm
+					continue
+				}
+				if other.module.Name() != name {
+					msgs = append(msgs, fmt.Sprintf("host %q: port %s is used by module %q of target group %q and module %q of target group %q", host, port, other.module.Name(), other.group, name, hm.group))
+				}
+			}
+		}
+	}
+
+	if len(msgs) > 0 {
+		return fmt.Errorf("conflicting target groups: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// enabledModuleConfigs returns the enabled modules of a target group.
+func enabledModuleConfigs(tg config.TargetGroup) []modules.Config {
+	if tg.Modules == nil {
+		return nil
+	}
+	var mods []modules.Config
+	for _, mod := range tg.Modules.ModulesConfigs {
+		if mod.IsEnabled() {
+			mods = append(mods, mod)
+		}
+	}
+	return mods
+}
+
+// targetHost returns the host of a target, without the port.
+func targetHost(t labels.Labels) string {
+	addr := t.Get(model.AddressLabel)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// validateRules checks the rules of the modules of every target group. The
+// rules of each module are checked against the scrape interval of the module,
+// and the rules of the target group against the one of the target group.
+func validateRules(groups []modules.GroupModel) error {
+	var errs []error
+	for _, g := range groups {
+		mods := make([]string, 0, len(g.RuleGroups))
+		for mod := range g.RuleGroups {
+			mods = append(mods, mod)
+		}
+		sort.Strings(mods)
+
+		var (
+			all      []rulefmt.RuleGroup
+			allFiles []string
+		)
+		for _, mod := range mods {
+			interval := modules.DefaultScrapeSettings.Merge(g.ScrapeSettings[mod]).ScrapeInterval
+			files := g.RuleFiles[mod]
+			for _, err := range rules.Check(g.RuleGroups[mod], files, time.Duration(interval)) {
+				errs = append(errs, fmt.Errorf("target group %q, module %q: %w", g.Name, mod, err))
+			}
+			for i := range g.RuleGroups[mod] {
+				all = append(all, g.RuleGroups[mod][i])
+				allFiles = append(allFiles, ruleFile(files, i))
+			}
+		}
+		for _, err := range rules.DuplicateAlerts(all, allFiles) {
+			errs = append(errs, fmt.Errorf("target group %q: %w", g.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, 0, len(errs))
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return fmt.Errorf("invalid rules: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// ruleFile returns the file of the rule group i, or an empty string if it
+// was not read from a file.
+func ruleFile(files []string, i int) string {
+	if i < len(files) {
+		return files[i]
+	}
+	return ""
+}
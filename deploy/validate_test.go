@@ -0,0 +1,248 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/roidelapluie/o11y-deploy/config"
+	"github.com/roidelapluie/o11y-deploy/modules"
+	_ "github.com/roidelapluie/o11y-deploy/modules/alertmanager"
+	_ "github.com/roidelapluie/o11y-deploy/modules/grafana"
+	_ "github.com/roidelapluie/o11y-deploy/modules/linux"
+	_ "github.com/roidelapluie/o11y-deploy/modules/portal"
+	_ "github.com/roidelapluie/o11y-deploy/modules/prometheus"
+)
+
+func TestValidateTargets(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: monitoring
+    modules:
+      prometheus_module:
+        enabled: true
+      linux_module:
+        enabled: true
+  - name: dashboards
+    modules:
+      grafana_module:
+        enabled: true
+        grafana_port: 9090
+      linux_module:
+        enabled: true
+  - name: more_monitoring
+    modules:
+      prometheus_module:
+        enabled: true
+  - name: alerting
+    modules:
+      alertmanager_module:
+        enabled: true
+      grafana_module:
+        enabled: true
+        grafana_port: 9090
+  - name: more_alerting
+    modules:
+      alertmanager_module:
+        enabled: true
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	host1 := labels.FromStrings("__address__", "host1:22")
+	host2 := labels.FromStrings("__address__", "host2:22")
+	host3 := labels.FromStrings("__address__", "host3:22")
+	host4 := labels.FromStrings("__address__", "host4:22")
+	host5 := labels.FromStrings("__address__", "host5:22")
+
+	err := validateTargets(&c, groupModuleTargets(&c, map[string][]labels.Labels{
+		"monitoring":      {host1},
+		"dashboards":      {host2},
+		"more_monitoring": {host3},
+		"alerting":        {host4},
+		"more_alerting":   {host5},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = validateTargets(&c, groupModuleTargets(&c, map[string][]labels.Labels{
+		"monitoring":      {host1},
+		"dashboards":      {host1},
+		"more_monitoring": {host1},
+		"alerting":        {host1},
+		"more_alerting":   {host1},
+	}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{
+		`host "host1": port 9090 is used by module "prometheus" of target group "monitoring" and module "grafana" of target group "dashboards"`,
+		`host "host1": module "prometheus" is enabled by target groups "monitoring" and "more_monitoring"`,
+		`host "host1": module "alertmanager" is enabled by target groups "alerting" and "more_alerting"`,
+		`host "host1": module "grafana" is enabled by target groups "dashboards" and "alerting"`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error %q", expected, err.Error())
+		}
+	}
+	if strings.Contains(err.Error(), `"linux"`) {
+		t.Errorf("linux module should be allowed in several target groups: %q", err.Error())
+	}
+
+	if errs := validateDependencies(&c); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	c.TargetGroups = c.TargetGroups[1:2]
+	if errs := validateDependencies(&c); len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+}
+
+func TestValidateStaticTargets(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: monitoring
+    targets:
+      static_configs:
+      - targets: [host1:22]
+    modules:
+      prometheus_module:
+        enabled: true
+  - name: more_monitoring
+    targets:
+      static_configs:
+      - targets: [host1:22, host2:22]
+      relabel_configs:
+      - source_labels: [__address__]
+        regex: host1:22
+        action: drop
+    modules:
+      prometheus_module:
+        enabled: true
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	d := &Deployer{cfg: &c}
+	// host1 is dropped by the relabel configs of more_monitoring.
+	if err := d.validateConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.TargetGroups[1].Targets.RelabelConfigs = nil
+	err := d.validateConfig()
+	expected := `host "host1": module "prometheus" is enabled by target groups "monitoring" and "more_monitoring"`
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q in error %v", expected, err)
+	}
+}
+
+func TestValidateClusterPorts(t *testing.T) {
+	yamlString := `
+target_groups:
+  - name: portal
+    modules:
+      portal_module:
+        enabled: true
+      grafana_module:
+        enabled: true
+        grafana_port: 9095
+  - name: monitoring
+    modules:
+      prometheus_module:
+        enabled: true
+`
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(yamlString), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	gmt := groupModuleTargets(&c, map[string][]labels.Labels{
+		"portal":     {labels.FromStrings("__address__", "host1:22")},
+		"monitoring": {labels.FromStrings("__address__", "host2:22")},
+	})
+
+	// Without highly available clusters, the portal does not proxy the
+	// datasources.
+	if err := validateTargets(&c, gmt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := yaml.Unmarshal([]byte(yamlString+`
+        ha:
+          enabled: true
+`), &c); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	err := validateTargets(&c, gmt)
+	expected := `host "host1": port 9095 is used by module "grafana" of target group "portal" and module "portal" of target group "portal"`
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q in error %v", expected, err)
+	}
+}
+
+func groupModuleTargets(c *config.Config, groupTargets map[string][]labels.Labels) map[string]map[string][]labels.Labels {
+	gmt := make(map[string]map[string][]labels.Labels)
+	for _, tg := range c.TargetGroups {
+		gmt[tg.Name] = moduleTargets(tg, groupTargets[tg.Name])
+	}
+	return gmt
+}
+
+func TestValidateRules(t *testing.T) {
+	alert := rulefmt.RuleNode{
+		Alert:       yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "Down"},
+		Expr:        yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "up == 0"},
+		For:         model.Duration(time.Minute),
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Down", "description": "Down"},
+	}
+	groups := []modules.GroupModel{
+		{
+			Name: "servers",
+			RuleGroups: map[string][]rulefmt.RuleGroup{
+				"linux": {{Name: "servers-linux", Rules: []rulefmt.RuleNode{alert}}},
+			},
+			ScrapeSettings: map[string]modules.ScrapeSettings{
+				"linux": {ScrapeInterval: model.Duration(30 * time.Second)},
+			},
+		},
+	}
+	if err := validateRules(groups); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rules of the target group are checked against its scrape
+	// interval, not the default one.
+	custom := alert
+	custom.Alert.Value = "CustomDown"
+	custom.Alert.Line, custom.Alert.Column = 4, 12
+	groups[0].RuleGroups[targetGroupRulesKey] = []rulefmt.RuleGroup{{Name: "servers-custom", Rules: []rulefmt.RuleNode{custom}}}
+	groups[0].RuleFiles = map[string][]string{targetGroupRulesKey: {"rules/db.yml"}}
+	groups[0].ScrapeSettings[targetGroupRulesKey] = modules.ScrapeSettings{ScrapeInterval: model.Duration(5 * time.Minute)}
+
+	groups[0].ScrapeSettings["linux"] = modules.ScrapeSettings{ScrapeInterval: model.Duration(2 * time.Minute)}
+	groups[0].RuleGroups["prometheus"] = []rulefmt.RuleGroup{{Name: "servers-prometheus", Rules: []rulefmt.RuleNode{alert}}}
+	err := validateRules(groups)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{
+		`target group "servers", module "linux": group "servers-linux", rule 1, "Down": for 1m is shorter than the scrape interval 2m0s`,
+		`target group "servers", module "custom": rules/db.yml:4:12: group "servers-custom", rule 1, "CustomDown": for 1m is shorter than the scrape interval 5m0s`,
+		`target group "servers": group "servers-prometheus", rule 1, "Down": alert already defined in group "servers-linux"`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in %q", expected, err)
+		}
+	}
+}
@@ -42,12 +42,25 @@ var (
 	ansibleSkipTags = kingpin.Flag("ansible.skip-tag", "Tag to skip").Strings()
 	ansibleLimit    = kingpin.Flag("ansible.limit", "Ansible limit").String()
 	modules         = kingpin.Flag("module", "Only run select modules").Strings()
+	parallelGroups  = kingpin.Flag("parallel-groups", "Deploy each target group in its own Ansible run, with up to this number of runs at a time").Default("1").Int()
+
+	deployCmd  = kingpin.Command("deploy", "Deploy the configuration to the targets").Default()
+	planCmd    = kingpin.Command("plan", "Render the deployment and print a summary, without running Ansible")
+	renderCmd  = kingpin.Command("render", "Write the deployment to a directory as a standalone Ansible project")
+	renderDir  = renderCmd.Arg("directory", "The directory to write the Ansible project to").Required().String()
+	diffCmd    = kingpin.Command("diff", "Show the differences between the configuration and the last deployment")
+	targetsCmd = kingpin.Command("targets", "Show the discovered targets after relabeling, and the addresses scraped by each module")
+	checkCmd   = kingpin.Command("check-config", "Check the configuration file and exit")
+
+	testRulesCmd         = kingpin.Command("test-rules", "Run the unit tests of the rules of the modules, and the given unit tests files")
+	testRulesFiles       = testRulesCmd.Arg("test-file", "Rules unit tests files, run against the rules of each target group").ExistingFiles()
+	testRulesTargetGroup = testRulesCmd.Flag("target-group", "Only test the rules of this target group").String()
 )
 
 func main() {
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
 	absDepsHome, err := filepath.Abs(*depsHome)
@@ -63,17 +76,99 @@ func main() {
 
 	depsHome = &absDepsHome
 
-	if err := preflightCheck(*depsHome); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
 	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
 		fmt.Printf("Error loading configuration: %v\n", err)
 		os.Exit(1)
 	}
 
+	switch cmd {
+	case checkCmd.FullCommand():
+		if errs := cfg.Validate(); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Printf("Error: %v\n", err)
+			}
+			os.Exit(1)
+		}
+		if _, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid.")
+		return
+	case planCmd.FullCommand():
+		deployer, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug)
+		if err != nil {
+			fmt.Printf("Error creating deployer: %v\n", err)
+			os.Exit(1)
+		}
+
+		plan, err := deployer.Plan(*modules)
+		if err != nil {
+			fmt.Printf("Error planning deployment: %v\n", err)
+			os.Exit(1)
+		}
+		plan.Print(os.Stdout)
+		return
+	case renderCmd.FullCommand():
+		deployer, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug)
+		if err != nil {
+			fmt.Printf("Error creating deployer: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := deployer.Render(*modules, *renderDir); err != nil {
+			fmt.Printf("Error rendering deployment: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case diffCmd.FullCommand():
+		deployer, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug)
+		if err != nil {
+			fmt.Printf("Error creating deployer: %v\n", err)
+			os.Exit(1)
+		}
+
+		changed, err := deployer.Diff(os.Stdout, *modules)
+		if err != nil {
+			fmt.Printf("Error computing diff: %v\n", err)
+			os.Exit(1)
+		}
+		if !changed {
+			fmt.Println("No changes since the last deployment.")
+		}
+		return
+	case targetsCmd.FullCommand():
+		deployer, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug)
+		if err != nil {
+			fmt.Printf("Error creating deployer: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := deployer.Targets(os.Stdout); err != nil {
+			fmt.Printf("Error getting targets: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case testRulesCmd.FullCommand():
+		deployer, err := deploy.NewDeployer(logger, cfg, absDepsHome, *ansibleDebug)
+		if err != nil {
+			fmt.Printf("Error creating deployer: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := deployer.TestRules(os.Stdout, *testRulesFiles, *testRulesTargetGroup); err != nil {
+			fmt.Printf("Error testing rules: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := preflightCheck(*depsHome); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	if *ara {
 		if !cfg.Global.EnableARA {
 			fmt.Printf("Ara is disabled in the configuration")
@@ -113,7 +208,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = deployer.Run(*modules, *ansibleSkipTags, *ansibleLimit); err != nil {
+	if err = deployer.Run(*modules, *ansibleSkipTags, *ansibleLimit, *parallelGroups); err != nil {
 		fmt.Printf("Error running deployer: %v\n", err)
 		os.Exit(1)
 	}
@@ -3,4 +3,34 @@ package promserver
 type PrometheusServer struct {
 	Name string
 	URL  string
+
+	// Group is the target group of the server.
+	Group string `json:",omitempty"`
+
+	// Agent is true when the server runs in agent mode, and can not be
+	// queried.
+	Agent bool `json:",omitempty"`
+
+	// Federation is true when the server federates the Prometheus servers
+	// of the other target groups.
+	Federation bool `json:",omitempty"`
+
+	// Cluster and Replica are set when the server is a replica of a highly
+	// available cluster.
+	Cluster string `json:",omitempty"`
+	Replica string `json:",omitempty"`
+}
+
+// Clusters returns the replicas of each highly available cluster, by cluster
+// name. The servers that are not part of a cluster and the agents are left
+// out, agents can not be queried.
+func Clusters(servers []PrometheusServer) map[string][]PrometheusServer {
+	clusters := make(map[string][]PrometheusServer)
+	for _, s := range servers {
+		if s.Cluster == "" || s.Agent {
+			continue
+		}
+		clusters[s.Cluster] = append(clusters[s.Cluster], s)
+	}
+	return clusters
 }
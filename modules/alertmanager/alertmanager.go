@@ -15,8 +15,10 @@ package alertmanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/mail"
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/roidelapluie/o11y-deploy/model/amserver"
@@ -47,6 +49,8 @@ type ModuleConfig struct {
 	Receivers     []string `yaml:"receivers"`
 	SmtpFrom      string   `yaml:"smtp_from"`
 	SmtpSmarthost string   `yaml:"smtp_smarthost"`
+
+	Scrape modules.ScrapeSettings `yaml:"scrape"`
 }
 
 func (m *ModuleConfig) Name() string {
@@ -66,9 +70,10 @@ func (m *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *ModuleConfig) NewModule(modules.ModuleOptions) (modules.Module, error) {
+func (m *ModuleConfig) NewModule(o modules.ModuleOptions) (modules.Module, error) {
 	return &Module{
-		cfg: m,
+		cfg:  m,
+		opts: o,
 	}, nil
 }
 
@@ -77,8 +82,54 @@ func (m *ModuleConfig) IsEnabled() bool {
 	return m.Enabled
 }
 
+// Ports implements the modules.PortsConfig interface.
+func (m *ModuleConfig) Ports() []string {
+	return []string{m.ListenPort}
+}
+
+// Singleton implements the modules.SingletonConfig interface: the
+// configuration of two target groups would overwrite each other.
+func (m *ModuleConfig) Singleton() bool {
+	return true
+}
+
+// ScrapeSettings implements the modules.ScrapedConfig interface.
+func (m *ModuleConfig) ScrapeSettings() modules.ScrapeSettings {
+	return m.Scrape
+}
+
+// Validate implements the modules.Validator interface.
+func (m *ModuleConfig) Validate() error {
+	if err := m.Scrape.Validate(); err != nil {
+		return fmt.Errorf("scrape: %w", err)
+	}
+	if err := modules.ValidatePort(m.ListenPort); err != nil {
+		return fmt.Errorf("listen_port: %w", err)
+	}
+	if len(m.Receivers) == 0 {
+		return errors.New("receivers: at least one receiver is required")
+	}
+	for _, r := range m.Receivers {
+		if _, err := mail.ParseAddress(r); err != nil {
+			return fmt.Errorf("receivers: invalid email address %q: %w", r, err)
+		}
+	}
+	if _, err := mail.ParseAddress(m.SmtpFrom); err != nil {
+		return fmt.Errorf("smtp_from: invalid email address %q: %w", m.SmtpFrom, err)
+	}
+	_, port, err := net.SplitHostPort(m.SmtpSmarthost)
+	if err != nil {
+		return fmt.Errorf("smtp_smarthost: %w", err)
+	}
+	if err := modules.ValidatePort(port); err != nil {
+		return fmt.Errorf("smtp_smarthost: %w", err)
+	}
+	return nil
+}
+
 type Module struct {
-	cfg *ModuleConfig
+	cfg  *ModuleConfig
+	opts modules.ModuleOptions
 }
 
 func mapEmailsToConfig(emails []string) []interface{} {
@@ -91,7 +142,7 @@ func mapEmailsToConfig(emails []string) []interface{} {
 	return result
 }
 
-func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
+func (m *Module) Playbook(c context.Context, dm *modules.DeploymentModel) (*ansible.Playbook, error) {
 
 	return &ansible.Playbook{
 		Name: "Alertmanager",
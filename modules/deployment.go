@@ -0,0 +1,112 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"encoding/json"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/model/amserver"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
+	"gopkg.in/yaml.v3"
+)
+
+// DeploymentModel is what the modules know about the whole deployment when
+// their playbooks are rendered. It is built by the deployer from the enabled
+// modules of every target group.
+type DeploymentModel struct {
+	Global GlobalModel  `json:"global"`
+	Groups []GroupModel `json:"target_groups"`
+}
+
+// GlobalModel is the part of the DeploymentModel that is not specific to a
+// target group.
+type GlobalModel struct {
+	// RulesDir is the directory where the rules files are written.
+	RulesDir string `json:"rules_dir"`
+
+	// FileSDDir is the directory where the file based service discovery
+	// files are written.
+	FileSDDir string `json:"file_sd_dir"`
+
+	// DashboardsDir is the directory where the Grafana dashboards files are
+	// written.
+	DashboardsDir string `json:"dashboards_dir"`
+
+	// RulesPath, FileSDPath and DashboardsPath are the paths of RulesDir,
+	// FileSDDir and DashboardsDir in the playbooks, e.g. relative to the
+	// playbook when the deployment is rendered. If empty, the playbooks use
+	// the directories.
+	RulesPath      string `json:"rules_path,omitempty"`
+	FileSDPath     string `json:"file_sd_path,omitempty"`
+	DashboardsPath string `json:"dashboards_path,omitempty"`
+
+	PrometheusServers   []promserver.PrometheusServer `json:"prometheus_servers"`
+	AlertmanagerServers []amserver.AlertmanagerServer `json:"alertmanager_servers"`
+	Dashboards          []json.RawMessage             `json:"dashboards"`
+	ReverseProxyEntries []ReverseProxyEntry           `json:"reverse_proxy_entries"`
+
+	// DatasourceProxies are the proxies of the highly available Prometheus
+	// clusters.
+	DatasourceProxies []DatasourceProxy `json:"datasource_proxies"`
+}
+
+// GroupModel is the part of the DeploymentModel that belongs to a single
+// target group.
+type GroupModel struct {
+	Name    string          `json:"name"`
+	Targets []labels.Labels `json:"targets"`
+
+	// ModuleTargets are the targets each enabled module is enabled on, by
+	// module name.
+	ModuleTargets map[string][]labels.Labels `json:"module_targets"`
+
+	// ScrapeTargets are the targets to scrape, by module name.
+	ScrapeTargets map[string][]labels.Labels `json:"scrape_targets"`
+
+	// ScrapeSettings override how the scrape targets are scraped, by module
+	// name.
+	ScrapeSettings map[string]ScrapeSettings `json:"scrape_settings"`
+
+	// RuleGroups are the rule groups of the modules of this target group
+	// only, by module name.
+	RuleGroups map[string][]rulefmt.RuleGroup `json:"-"`
+
+	// RuleFiles are the files the rule groups were read from, by module name
+	// then index of the rule group, for the error messages. They are empty
+	// for the rule groups generated by the modules.
+	RuleFiles map[string][]string `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface. Rule groups are
+// written as they would be in a rules file.
+func (g GroupModel) MarshalJSON() ([]byte, error) {
+	type plain GroupModel
+	data, err := yaml.Marshal(g.RuleGroups)
+	if err != nil {
+		return nil, err
+	}
+	var ruleGroups map[string]interface{}
+	if err := yaml.Unmarshal(data, &ruleGroups); err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		plain
+		RuleGroups map[string]interface{} `json:"rule_groups"`
+	}{
+		plain:      plain(g),
+		RuleGroups: ruleGroups,
+	})
+}
@@ -19,14 +19,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 
+	"github.com/go-kit/log/level"
 	"github.com/roidelapluie/o11y-deploy/model/ansible"
-	"github.com/roidelapluie/o11y-deploy/model/ctx"
 	"github.com/roidelapluie/o11y-deploy/model/dashboard"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
 	"github.com/roidelapluie/o11y-deploy/modules"
 	"github.com/roidelapluie/o11y-deploy/util"
 
@@ -56,6 +61,8 @@ type ModuleConfig struct {
 	GrafanaAddress    string `yaml:"grafana_address"`
 	GrafanaPort       int64  `yaml:"grafana_port"`
 	AutoAssignOrgRole string `yaml:"users_role"`
+
+	Scrape modules.ScrapeSettings `yaml:"scrape"`
 }
 
 type GrafanaServerConfig struct {
@@ -100,9 +107,10 @@ func (m *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *ModuleConfig) NewModule(modules.ModuleOptions) (modules.Module, error) {
+func (m *ModuleConfig) NewModule(o modules.ModuleOptions) (modules.Module, error) {
 	return &Module{
-		cfg: m,
+		cfg:  m,
+		opts: o,
 	}, nil
 }
 
@@ -111,25 +119,74 @@ func (m *ModuleConfig) IsEnabled() bool {
 	return m.Enabled
 }
 
+// Requires implements the modules.DependentConfig interface. The Prometheus
+// servers are the datasources of Grafana.
+func (m *ModuleConfig) Requires() []string {
+	return []string{"prometheus"}
+}
+
+// After implements the modules.DependentConfig interface.
+func (m *ModuleConfig) After() []string {
+	return nil
+}
+
+// Ports implements the modules.PortsConfig interface.
+func (m *ModuleConfig) Ports() []string {
+	return []string{strconv.FormatInt(m.GrafanaPort, 10)}
+}
+
+// Singleton implements the modules.SingletonConfig interface: the
+// configuration of two target groups would overwrite each other.
+func (m *ModuleConfig) Singleton() bool {
+	return true
+}
+
+// ScrapeSettings implements the modules.ScrapedConfig interface.
+func (m *ModuleConfig) ScrapeSettings() modules.ScrapeSettings {
+	return m.Scrape
+}
+
+// Validate implements the modules.Validator interface.
+func (m *ModuleConfig) Validate() error {
+	if err := m.Scrape.Validate(); err != nil {
+		return fmt.Errorf("scrape: %w", err)
+	}
+	switch m.AutoAssignOrgRole {
+	case "Viewer", "Editor", "Admin":
+	default:
+		return fmt.Errorf("users_role: invalid role %q, must be one of Viewer, Editor or Admin", m.AutoAssignOrgRole)
+	}
+	if err := modules.ValidatePort(strconv.FormatInt(m.GrafanaPort, 10)); err != nil {
+		return fmt.Errorf("grafana_port: %w", err)
+	}
+	if net.ParseIP(m.GrafanaAddress) == nil {
+		return fmt.Errorf("grafana_address: invalid IP address %q", m.GrafanaAddress)
+	}
+	return nil
+}
+
 type Module struct {
-	cfg *ModuleConfig
+	cfg  *ModuleConfig
+	opts modules.ModuleOptions
 }
 
-func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
+func (m *Module) Playbook(c context.Context, dm *modules.DeploymentModel) (*ansible.Playbook, error) {
 
-	ctxDashboards := ctx.GetDashboards(c)
-	dir := ctx.GetDatadir(c)
-	directoryPath := dir + "/dashboards"
+	directoryPath := dm.Global.DashboardsDir
 	if _, err := os.Stat(directoryPath); os.IsNotExist(err) {
-		err := os.Mkdir(directoryPath, 0755)
+		err := os.MkdirAll(directoryPath, 0755)
 		if err != nil {
 			return nil, err
 		}
 	}
+	dashboardsPath := directoryPath
+	if dm.Global.DashboardsPath != "" {
+		dashboardsPath = dm.Global.DashboardsPath
+	}
 
 	expectedFiles := make(map[string]bool)
 
-	for _, d := range ctxDashboards {
+	for _, d := range dm.Global.Dashboards {
 		var dashboar dashboard.Dashboard
 		err := json.Unmarshal(d, &dashboar)
 		if err != nil {
@@ -220,12 +277,25 @@ func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
 	}
 
 	grafanaDS := make([]map[string]interface{}, 0)
-	for _, s := range ctx.GetPromServers(c) {
+	clusters := make(map[string]bool)
+	for _, s := range dm.Global.PrometheusServers {
+		if s.Agent {
+			continue
+		}
+		name, dsURL := s.Name, s.URL
+		// A highly available cluster is a single datasource.
+		if s.Cluster != "" {
+			if clusters[s.Cluster] {
+				continue
+			}
+			clusters[s.Cluster] = true
+			name, dsURL = s.Cluster, m.clusterURL(dm, s)
+		}
 		grafanaDS = append(grafanaDS, map[string]interface{}{
-			"name":       s.Name,
+			"name":       name,
 			"type":       "prometheus",
 			"access":     "proxy",
-			"url":        s.URL,
+			"url":        dsURL,
 			"basic_auth": false,
 		})
 	}
@@ -242,7 +312,7 @@ func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
 			"grafana_address":        m.cfg.GrafanaAddress,
 			"grafana_port":           m.cfg.GrafanaPort,
 			"grafana_datasources":    grafanaDS,
-			"grafana_dashboards_dir": directoryPath,
+			"grafana_dashboards_dir": dashboardsPath,
 			"grafana_metrics": map[string]interface{}{
 				"enabled": true,
 			},
@@ -392,3 +462,30 @@ func (m *Module) ReverseProxy(targets []labels.Labels, group string) ([]modules.
 
 	return rp, nil
 }
+
+// clusterURL returns the URL of the datasource of a highly available
+// Prometheus cluster: the datasource proxy of the portal, that fails over
+// between the replicas. Without portal, it is the replica s.
+//
+// The proxy is picked among the portals of the target group of the cluster,
+// or among all the portals if it has none, by a hash of the cluster name, so
+// that losing a portal only breaks the datasources of some clusters.
+func (m *Module) clusterURL(dm *modules.DeploymentModel, s promserver.PrometheusServer) string {
+	var proxies []modules.DatasourceProxy
+	for _, p := range dm.Global.DatasourceProxies {
+		if p.Group == s.Group {
+			proxies = append(proxies, p)
+		}
+	}
+	if len(proxies) == 0 {
+		proxies = dm.Global.DatasourceProxies
+	}
+	if len(proxies) == 0 {
+		level.Warn(m.opts.Logger).Log("msg", "No portal to fail over between the Prometheus replicas, the datasource only queries one of them", "cluster", s.Cluster, "replica", s.Replica)
+		return s.URL
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s.Cluster))
+	p := proxies[h.Sum32()%uint32(len(proxies))]
+	return p.URL + "/" + url.PathEscape(s.Cluster) + "/"
+}
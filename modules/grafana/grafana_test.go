@@ -0,0 +1,48 @@
+package grafana
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+func TestClusterURL(t *testing.T) {
+	m := &Module{opts: modules.ModuleOptions{Logger: log.NewNopLogger()}}
+	dm := &modules.DeploymentModel{
+		Global: modules.GlobalModel{
+			DatasourceProxies: []modules.DatasourceProxy{
+				{URL: "http://portal1:9095", Group: "central"},
+				{URL: "http://portal2:9095", Group: "central"},
+				{URL: "http://portal3:9095", Group: "us"},
+			},
+		},
+	}
+
+	// The portal of the target group of the cluster is preferred.
+	u := m.clusterURL(dm, promserver.PrometheusServer{URL: "http://us1a:9090/", Group: "us", Cluster: "us1"})
+	if u != "http://portal3:9095/us1/" {
+		t.Fatalf("expected the portal of the target group, got %s", u)
+	}
+
+	// Otherwise, the clusters are spread over all the portals.
+	used := map[string]bool{}
+	for _, c := range []string{"eu1", "eu2", "eu3", "eu4", "eu5", "eu6"} {
+		s := promserver.PrometheusServer{URL: "http://replica:9090/", Group: "eu", Cluster: c}
+		u := m.clusterURL(dm, s)
+		if u2 := m.clusterURL(dm, s); u2 != u {
+			t.Fatalf("expected the same proxy for cluster %s, got %s and %s", c, u, u2)
+		}
+		used[u[:len("http://portalX:9095")]] = true
+	}
+	if len(used) < 2 {
+		t.Fatalf("expected the clusters to use several portals, got %v", used)
+	}
+
+	// Without portal, the replica is queried directly.
+	u = m.clusterURL(&modules.DeploymentModel{}, promserver.PrometheusServer{URL: "http://eu1a:9090/", Cluster: "eu1"})
+	if u != "http://eu1a:9090/" {
+		t.Fatalf("expected the replica, got %s", u)
+	}
+}
@@ -13,3 +13,50 @@ type PrometheusModule interface {
 type AlertmanagerModule interface {
 	GetAlertmanagerServers([]labels.Labels, string) ([]amserver.AlertmanagerServer, error)
 }
+
+// A DatasourceProxyModule is a module that proxies the queries to the highly
+// available Prometheus clusters, failing over between their replicas.
+type DatasourceProxyModule interface {
+	GetDatasourceProxies([]labels.Labels, string) ([]DatasourceProxy, error)
+}
+
+// A PortsConfig is the Config of a module that listens on TCP ports on the
+// targets.
+type PortsConfig interface {
+	Ports() []string
+}
+
+// A ClusterPortsConfig is a PortsConfig with ports that are only used when
+// highly available Prometheus clusters are deployed.
+type ClusterPortsConfig interface {
+	ClusterPorts() []string
+}
+
+// A ClusterConfig is the Config of a module that deploys highly available
+// Prometheus clusters.
+type ClusterConfig interface {
+	HighlyAvailable() bool
+}
+
+// A SingletonConfig is the Config of a module that can only be enabled once
+// per target, even across target groups.
+type SingletonConfig interface {
+	Singleton() bool
+}
+
+// A DependentConfig is the Config of a module that depends on other modules.
+type DependentConfig interface {
+	// Requires returns the names of the modules that must be enabled in a
+	// target group for this module to work. They are deployed before it.
+	Requires() []string
+
+	// After returns the names of the modules that must be deployed before
+	// this module, if they are enabled.
+	After() []string
+}
+
+// A RulesTestedModule is a module that ships unit tests for the rules it
+// returns, in the format of the rules unit tests files.
+type RulesTestedModule interface {
+	RulesTests() []byte
+}
@@ -15,6 +15,7 @@ package linux
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/roidelapluie/o11y-deploy/model/ansible"
 	"github.com/roidelapluie/o11y-deploy/modules"
@@ -36,6 +37,8 @@ type ModuleConfig struct {
 	Enabled             bool   `yaml:"enabled"`
 	EnableExporter      bool   `yaml:"enable_exporter"`
 	NodeExporterVersion string `yaml:"node_exporter_version"`
+
+	Scrape modules.ScrapeSettings `yaml:"scrape"`
 }
 
 func (m *ModuleConfig) Name() string {
@@ -55,9 +58,10 @@ func (m *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *ModuleConfig) NewModule(modules.ModuleOptions) (modules.Module, error) {
+func (m *ModuleConfig) NewModule(o modules.ModuleOptions) (modules.Module, error) {
 	return &Module{
-		cfg: m,
+		cfg:  m,
+		opts: o,
 	}, nil
 }
 
@@ -66,11 +70,33 @@ func (m *ModuleConfig) IsEnabled() bool {
 	return m.Enabled
 }
 
+// Ports implements the modules.PortsConfig interface.
+func (m *ModuleConfig) Ports() []string {
+	if !m.EnableExporter {
+		return nil
+	}
+	return []string{"9100"}
+}
+
+// ScrapeSettings implements the modules.ScrapedConfig interface.
+func (m *ModuleConfig) ScrapeSettings() modules.ScrapeSettings {
+	return m.Scrape
+}
+
+// Validate implements the modules.Validator interface.
+func (m *ModuleConfig) Validate() error {
+	if err := m.Scrape.Validate(); err != nil {
+		return fmt.Errorf("scrape: %w", err)
+	}
+	return nil
+}
+
 type Module struct {
-	cfg *ModuleConfig
+	cfg  *ModuleConfig
+	opts modules.ModuleOptions
 }
 
-func (m *Module) Playbook(ctx context.Context) (*ansible.Playbook, error) {
+func (m *Module) Playbook(ctx context.Context, dm *modules.DeploymentModel) (*ansible.Playbook, error) {
 	if !m.cfg.EnableExporter {
 		return nil, nil
 	}
@@ -14,6 +14,7 @@
 package linux
 
 import (
+	_ "embed"
 	"fmt"
 	"time"
 
@@ -22,6 +23,14 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+//go:embed rules_test.yaml
+var rulesTests []byte
+
+// RulesTests implements the modules.RulesTestedModule interface.
+func (m *Module) RulesTests() []byte {
+	return rulesTests
+}
+
 // GetRules returns recording and alerting rules for this module.
 func (m *Module) GetRules(tg string) rulefmt.RuleGroup {
 	return rulefmt.RuleGroup{
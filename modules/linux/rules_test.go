@@ -0,0 +1,12 @@
+package linux
+
+import (
+	"testing"
+
+	"github.com/roidelapluie/o11y-deploy/rules/rulestest"
+)
+
+func TestRules(t *testing.T) {
+	m := &Module{cfg: &DefaultConfig}
+	rulestest.Run(t, "rules_test.yaml", m.GetRules("servers"))
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/common/config"
@@ -29,7 +30,9 @@ import (
 
 // Module is the interface for modules.
 type Module interface {
-	Playbook(context.Context) (*ansible.Playbook, error)
+	// Playbook returns the playbook of the module. It can be nil if there is
+	// nothing to deploy.
+	Playbook(context.Context, *DeploymentModel) (*ansible.Playbook, error)
 	HostVars(target labels.Labels, group string) (map[string]interface{}, error)
 	GetTargets([]labels.Labels, string) ([]labels.Labels, error)
 	GetRules(string) rulefmt.RuleGroup
@@ -39,6 +42,19 @@ type Module interface {
 // ModuleOptions provides options for a Module.
 type ModuleOptions struct {
 	Logger log.Logger
+
+	// DataDir is the directory where the deployment data is kept.
+	DataDir string
+
+	// ReadOnly is set when the deployment is only planned or rendered. The
+	// module must not write to DataDir.
+	ReadOnly bool
+
+	// TargetGroup is the name of the target group the module is created for.
+	TargetGroup string
+
+	// Store is shared by the instances of the module in every target group.
+	Store *Store
 }
 
 // A Config provides the configuration and constructor for a Module.
@@ -54,6 +70,13 @@ type Config interface {
 	NewModule(ModuleOptions) (Module, error)
 }
 
+// A Validator is a Config that can check its own values, before any module is
+// created.
+type Validator interface {
+	// Validate returns an error if the configuration is not valid.
+	Validate() error
+}
+
 // Configs is a slice of Config values that uses custom YAML marshaling and unmarshaling
 // to represent itself as a mapping of the Config values grouped by their types.
 type Configs []Config
@@ -148,3 +171,12 @@ func GetReverseProxyAddress(target labels.Labels, name, prefix, group string) (s
 	}
 	return "{{o11y_portal_address|default(\"\")}}" + prefix + "/", nil
 }
+
+// ValidatePort checks that port is a valid TCP port number.
+func ValidatePort(port string) error {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package portal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// prometheusCluster is a highly available Prometheus cluster, as the portal
+// proxies it.
+type prometheusCluster struct {
+	// Prefix is where the portal serves the cluster, /<cluster>/.
+	Prefix string `yaml:"prefix"`
+	// Path is the path of Prometheus on the replicas, without trailing
+	// slash.
+	Path string `yaml:"path"`
+	// Upstreams are the host:port of the replicas.
+	Upstreams []string `yaml:"upstreams"`
+}
+
+// prometheusClusters returns the highly available Prometheus clusters, sorted
+// by name.
+func prometheusClusters(servers []promserver.PrometheusServer) ([]prometheusCluster, error) {
+	byName := promserver.Clusters(servers)
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clusters := make([]prometheusCluster, 0, len(names))
+	for _, name := range names {
+		c := prometheusCluster{Prefix: "/" + url.PathEscape(name) + "/"}
+		for _, s := range byName[name] {
+			u, err := url.Parse(s.URL)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse prometheus url: %v", err)
+			}
+			c.Path = strings.TrimSuffix(u.Path, "/")
+			c.Upstreams = append(c.Upstreams, u.Host)
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// datasourceClients returns the hosts of the Grafana targets, sorted, that are
+// the only clients allowed to query the clusters through the datasource
+// proxy: the proxy itself does not authenticate them.
+func datasourceClients(dm *modules.DeploymentModel) []string {
+	seen := map[string]struct{}{}
+	for _, g := range dm.Groups {
+		for _, t := range g.ModuleTargets["grafana"] {
+			addr := t.Get(model.AddressLabel)
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			if host != "" {
+				seen[host] = struct{}{}
+			}
+		}
+	}
+	hosts := make([]string, 0, len(seen))
+	for h := range seen {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,55 @@
+package portal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+func TestPrometheusClusters(t *testing.T) {
+	clusters, err := prometheusClusters([]promserver.PrometheusServer{
+		{Name: "prometheus", URL: "http://site1:9090/prometheus/", Group: "site"},
+		{Name: "prometheus", URL: "http://eu1a:9090/prometheus/", Group: "eu", Cluster: "eu1", Replica: "eu1a"},
+		{Name: "prometheus", URL: "http://eu1b:9090/prometheus/", Group: "eu", Cluster: "eu1", Replica: "eu1b"},
+		{Name: "prometheus", URL: "http://edge1:9090/prometheus/", Group: "edge", Cluster: "edge", Replica: "edge1", Agent: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []prometheusCluster{
+		{Prefix: "/eu1/", Path: "/prometheus", Upstreams: []string{"eu1a:9090", "eu1b:9090"}},
+	}
+	if !reflect.DeepEqual(clusters, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, clusters)
+	}
+}
+
+func TestDatasourceClients(t *testing.T) {
+	clients := datasourceClients(&modules.DeploymentModel{
+		Groups: []modules.GroupModel{
+			{
+				Name: "eu",
+				ModuleTargets: map[string][]labels.Labels{
+					"grafana":    {labels.FromStrings("__address__", "grafana1:22")},
+					"prometheus": {labels.FromStrings("__address__", "eu1a")},
+				},
+			},
+			{
+				Name: "us",
+				ModuleTargets: map[string][]labels.Labels{
+					"grafana": {
+						labels.FromStrings("__address__", "[2001:db8::1]:22"),
+						labels.FromStrings("__address__", "grafana1"),
+					},
+				},
+			},
+		},
+	})
+	expected := []string{"2001:db8::1", "grafana1"}
+	if !reflect.DeepEqual(clients, expected) {
+		t.Fatalf("expected %v, got %v", expected, clients)
+	}
+}
@@ -13,6 +13,12 @@ import (
 const (
 	passwordLength = 10
 	passwordFile   = "admin-password"
+	// initialPasswordFile holds the generated password, for the
+	// administrator to read it.
+	initialPasswordFile = "admin-password.initial"
+	// placeholderHash stands for the hash of the password of the webadmin
+	// user when it has not been generated yet. It matches no password.
+	placeholderHash = "*"
 )
 
 func generatePassword(length int) (string, error) {
@@ -31,6 +37,13 @@ func hashAndSavePassword(password, filePath string) (string, error) {
 	return string(hashedPassword), ioutil.WriteFile(filePath, hashedPassword, 0600)
 }
 
+// saveInitialPassword writes the generated password to a file that only the
+// current user can read, and returns the path of the file.
+func saveInitialPassword(password, dataDir string) (string, error) {
+	filePath := filepath.Join(dataDir, initialPasswordFile)
+	return filePath, ioutil.WriteFile(filePath, []byte(password+"\n"), 0600)
+}
+
 func getBcryptFromFile(filePath string) (string, error) {
 	hash, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -39,6 +52,16 @@ func getBcryptFromFile(filePath string) (string, error) {
 	return string(hash), nil
 }
 
+// readPassword returns the hash of the password saved in dataDir, or an empty
+// string if there is none.
+func readPassword(dataDir string) (string, error) {
+	hash, err := getBcryptFromFile(filepath.Join(dataDir, passwordFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return hash, err
+}
+
 func getOrGetPassword(dataDir string) (string, string, error) {
 	filePath := filepath.Join(dataDir, passwordFile)
 
@@ -18,11 +18,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/mail"
 	"strings"
 
+	"github.com/go-kit/log/level"
 	"github.com/google/uuid"
 	"github.com/roidelapluie/o11y-deploy/model/ansible"
-	"github.com/roidelapluie/o11y-deploy/model/ctx"
 	"github.com/roidelapluie/o11y-deploy/modules"
 	"golang.org/x/crypto/bcrypt"
 
@@ -31,8 +32,9 @@ import (
 )
 
 var DefaultConfig = ModuleConfig{
-	Enabled:      false,
-	AuthpVersion: "1.0.3",
+	Enabled:             false,
+	AuthpVersion:        "1.0.3",
+	DatasourceProxyPort: "9095",
 }
 
 func init() {
@@ -43,6 +45,10 @@ type ModuleConfig struct {
 	Enabled      bool   `yaml:"enabled"`
 	AuthpVersion string `yaml:"authp_version"`
 	Users        []User `yaml:"users"`
+
+	// DatasourceProxyPort is the port where the portal proxies the queries
+	// of Grafana to the highly available Prometheus clusters.
+	DatasourceProxyPort string `yaml:"datasource_proxy_port"`
 }
 
 type User struct {
@@ -72,9 +78,10 @@ func (m *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *ModuleConfig) NewModule(modules.ModuleOptions) (modules.Module, error) {
+func (m *ModuleConfig) NewModule(o modules.ModuleOptions) (modules.Module, error) {
 	return &Module{
-		cfg: m,
+		cfg:  m,
+		opts: o,
 	}, nil
 }
 
@@ -83,11 +90,69 @@ func (m *ModuleConfig) IsEnabled() bool {
 	return m.Enabled
 }
 
+// Requires implements the modules.DependentConfig interface.
+func (m *ModuleConfig) Requires() []string {
+	return nil
+}
+
+// After implements the modules.DependentConfig interface. The portal proxies
+// the web interfaces of these modules.
+func (m *ModuleConfig) After() []string {
+	return []string{"alertmanager", "grafana", "prometheus"}
+}
+
+// Ports implements the modules.PortsConfig interface.
+func (m *ModuleConfig) Ports() []string {
+	return []string{"80"}
+}
+
+// ClusterPorts implements the modules.ClusterPortsConfig interface. The
+// datasource proxy is only configured when there are highly available
+// Prometheus clusters.
+func (m *ModuleConfig) ClusterPorts() []string {
+	return []string{m.DatasourceProxyPort}
+}
+
+// Singleton implements the modules.SingletonConfig interface.
+func (m *ModuleConfig) Singleton() bool {
+	return true
+}
+
+// Validate implements the modules.Validator interface.
+func (m *ModuleConfig) Validate() error {
+	if err := modules.ValidatePort(m.DatasourceProxyPort); err != nil {
+		return fmt.Errorf("datasource_proxy_port: %w", err)
+	}
+	usernames := make(map[string]bool, len(m.Users))
+	for i, user := range m.Users {
+		if user.Username == "" {
+			return fmt.Errorf("users[%d]: username is required", i)
+		}
+		if usernames[user.Username] {
+			return fmt.Errorf("users[%d]: duplicate username %q", i, user.Username)
+		}
+		usernames[user.Username] = true
+		if _, err := bcrypt.Cost([]byte(user.BcryptPassword)); err != nil {
+			return fmt.Errorf("users[%d]: invalid bcrypt_password for user %q: %w", i, user.Username, err)
+		}
+		if _, err := mail.ParseAddress(user.Email); err != nil {
+			return fmt.Errorf("users[%d]: invalid email address %q for user %q: %w", i, user.Email, user.Username, err)
+		}
+		switch user.Role {
+		case "", "user", "admin":
+		default:
+			return fmt.Errorf("users[%d]: invalid role %q for user %q, must be user or admin", i, user.Role, user.Username)
+		}
+	}
+	return nil
+}
+
 type Module struct {
-	cfg *ModuleConfig
+	cfg  *ModuleConfig
+	opts modules.ModuleOptions
 }
 
-func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
+func (m *Module) Playbook(c context.Context, dm *modules.DeploymentModel) (*ansible.Playbook, error) {
 	users := m.cfg.Users
 
 	var admin bool
@@ -111,17 +176,10 @@ func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
 	}
 
 	if !admin {
-		dataDir := ctx.GetDatadir(c)
-		if dataDir == "" {
-			return nil, errors.New("Data directory not found")
-		}
-		pw, hash, err := getOrGetPassword(dataDir)
+		hash, err := m.webadminPassword()
 		if err != nil {
 			return nil, err
 		}
-		if pw != "" {
-			fmt.Printf("webadmin password is %s\n", pw)
-		}
 		users = append(users, User{
 			Username:       "webadmin",
 			BcryptPassword: hash,
@@ -132,12 +190,20 @@ func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
 		})
 	}
 
+	clusters, err := prometheusClusters(dm.Global.PrometheusServers)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ansible.Playbook{
 		Name: "Portal",
 		Vars: map[string]interface{}{
-			"authp_version":      m.cfg.AuthpVersion,
-			"authp_users":        users,
-			"o11y_proxy_entries": ctx.GetReverseProxyEntries(c),
+			"authp_version":                 m.cfg.AuthpVersion,
+			"authp_users":                   users,
+			"o11y_proxy_entries":            dm.Global.ReverseProxyEntries,
+			"o11y_datasource_proxy_port":    m.cfg.DatasourceProxyPort,
+			"o11y_prometheus_clusters":      clusters,
+			"o11y_datasource_proxy_clients": datasourceClients(dm),
 		},
 		Hosts:  "all",
 		Become: true,
@@ -147,6 +213,60 @@ func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
 	}, nil
 }
 
+// webadminPassword returns the bcrypt hash of the password of the webadmin
+// user, generating it on first use. The hash is kept in the module store, so
+// that the password is only read or generated once per deployment. When the
+// deployment is only planned or rendered, the password is not generated and a
+// placeholder is returned instead.
+func (m *Module) webadminPassword() (string, error) {
+	if hash, ok := m.opts.Store.Get("webadmin_hash"); ok {
+		return hash.(string), nil
+	}
+	if m.opts.DataDir == "" {
+		return "", errors.New("Data directory not found")
+	}
+	if m.opts.ReadOnly {
+		hash, err := readPassword(m.opts.DataDir)
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			level.Warn(m.opts.Logger).Log("msg", "The password of the webadmin user will be generated by the deployment")
+			hash = placeholderHash
+		}
+		m.opts.Store.Set("webadmin_hash", hash)
+		return hash, nil
+	}
+	pw, hash, err := getOrGetPassword(m.opts.DataDir)
+	if err != nil {
+		return "", err
+	}
+	if pw != "" {
+		// Do not log the password, logs are often shared or collected.
+		path, err := saveInitialPassword(pw, m.opts.DataDir)
+		if err != nil {
+			return "", fmt.Errorf("could not save the password of the webadmin user: %w", err)
+		}
+		level.Warn(m.opts.Logger).Log("msg", "Generated the password of the webadmin user", "file", path)
+	}
+	m.opts.Store.Set("webadmin_hash", hash)
+	return hash, nil
+}
+
+// GetDatasourceProxies implements the modules.DatasourceProxyModule
+// interface.
+func (m *Module) GetDatasourceProxies(targets []labels.Labels, group string) ([]modules.DatasourceProxy, error) {
+	rp, err := modules.GetReverseProxy(targets, m.cfg.DatasourceProxyPort, m.cfg.Name(), "", group)
+	if err != nil {
+		return nil, err
+	}
+	proxies := make([]modules.DatasourceProxy, 0, len(rp))
+	for _, r := range rp {
+		proxies = append(proxies, modules.DatasourceProxy{URL: r.URL, Group: group})
+	}
+	return proxies, nil
+}
+
 func (m *Module) GetTargets(labels []labels.Labels, group string) ([]labels.Labels, error) {
 	return nil, nil
 	// return modules.GetTargets(labels, "3000", group)
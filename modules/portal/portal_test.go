@@ -0,0 +1,46 @@
+package portal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+func TestWebadminPasswordReadOnly(t *testing.T) {
+	dataDir := t.TempDir()
+	opts := modules.ModuleOptions{
+		Logger:   log.NewNopLogger(),
+		DataDir:  dataDir,
+		ReadOnly: true,
+		Store:    modules.NewStore(),
+	}
+	m := &Module{cfg: &DefaultConfig, opts: opts}
+	hash, err := m.webadminPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != placeholderHash {
+		t.Fatalf("expected the placeholder hash, got %q", hash)
+	}
+	if entries, err := os.ReadDir(dataDir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty data directory, got %v (%v)", entries, err)
+	}
+
+	// Once deployed, the saved password is used.
+	opts.ReadOnly = false
+	opts.Store = modules.NewStore()
+	m = &Module{cfg: &DefaultConfig, opts: opts}
+	deployed, err := m.webadminPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.ReadOnly = true
+	opts.Store = modules.NewStore()
+	m = &Module{cfg: &DefaultConfig, opts: opts}
+	if hash, err := m.webadminPassword(); err != nil || hash != deployed {
+		t.Fatalf("expected %q, got %q (%v)", deployed, hash, err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+)
+
+// cleanupTasks are the tasks that remove, from the Prometheus hosts, the
+// files of dir that match pattern and whose names are not in the names
+// variable. If notify is not empty, the handler is notified of the removals.
+func cleanupTasks(what, dir, pattern, names, notify string) []ansible.Task {
+	register := fmt.Sprintf("o11y_prometheus_%s_files", what)
+	tasks := []ansible.Task{
+		{
+			Name: fmt.Sprintf("Find the Prometheus %s files", what),
+			Config: map[string]interface{}{
+				"ansible.builtin.find": map[string]interface{}{
+					"paths":    dir,
+					"patterns": pattern,
+				},
+				"register": register,
+			},
+		},
+		{
+			Name: fmt.Sprintf("Remove the stale Prometheus %s files", what),
+			Config: map[string]interface{}{
+				"ansible.builtin.file": map[string]interface{}{
+					"path":  "{{ item.path }}",
+					"state": "absent",
+				},
+				"loop": fmt.Sprintf("{{ %s.files }}", register),
+				"loop_control": map[string]interface{}{
+					"label": "{{ item.path }}",
+				},
+				"when": fmt.Sprintf("item.path | basename not in %s", names),
+			},
+		},
+	}
+	if notify != "" {
+		tasks[1].Config["notify"] = notify
+	}
+	return tasks
+}
+
+// fileNames returns the base names of the files.
+func fileNames(files []string) []string {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	return names
+}
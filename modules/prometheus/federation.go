@@ -0,0 +1,154 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// FederationConfig makes the Prometheus servers of a target group federate
+// the Prometheus servers of the other target groups, instead of scraping
+// their targets.
+type FederationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Match are the match[] selectors of the series to federate. They
+	// default to the recording rules.
+	Match []string `yaml:"match"`
+}
+
+// Validate checks the selectors of the federation configuration.
+func (c FederationConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Match) == 0 {
+		return errors.New("at least one match selector is required")
+	}
+	for _, s := range c.Match {
+		if _, err := parser.ParseMetricSelector(s); err != nil {
+			return fmt.Errorf("invalid match selector %q: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// federatedGroups returns the target groups that are federated, with the URLs
+// of their Prometheus servers. A target group is federated when it has its
+// own Prometheus servers, that are not agents and do not federate themselves.
+func (m *Module) federatedGroups(dm *modules.DeploymentModel) map[string][]string {
+	if !m.cfg.Federation.Enabled {
+		return nil
+	}
+	groups := make(map[string][]string)
+	for _, s := range dm.Global.PrometheusServers {
+		if s.Group == "" || s.Group == m.opts.TargetGroup || s.Agent || s.Federation {
+			continue
+		}
+		groups[s.Group] = append(groups[s.Group], s.URL)
+	}
+	return groups
+}
+
+// scrapedGroups returns the target groups whose targets are scraped by the
+// Prometheus servers of the module, and whose rules they evaluate. Without
+// federation, that is every target group. With federation, the federating
+// servers scrape the target groups that they do not federate, and the other
+// servers only their own target group.
+func (m *Module) scrapedGroups(dm *modules.DeploymentModel) map[string]bool {
+	federated := m.federatedGroups(dm)
+	var federating bool
+	for _, s := range dm.Global.PrometheusServers {
+		if s.Federation && s.Group != m.opts.TargetGroup {
+			federating = true
+		}
+	}
+
+	groups := make(map[string]bool)
+	for _, g := range dm.Groups {
+		switch {
+		case m.cfg.Federation.Enabled:
+			if _, ok := federated[g.Name]; !ok {
+				groups[g.Name] = true
+			}
+		case federating:
+			if g.Name == m.opts.TargetGroup {
+				groups[g.Name] = true
+			}
+		default:
+			groups[g.Name] = true
+		}
+	}
+	return groups
+}
+
+// federationScrapeConfigs returns a /federate scrape config per federated
+// target group, named federate_<target group>. The servers are grouped by
+// scheme and path in static configs, and the static configs of the servers
+// whose scheme or path differ from the ones of the job set them with the
+// __scheme__ and __metrics_path__ labels.
+func (m *Module) federationScrapeConfigs(federated map[string][]string) ([]ScrapeConfig, error) {
+	scrapeConfigs := []ScrapeConfig{}
+	for group, urls := range federated {
+		sort.Strings(urls)
+		var sc *ScrapeConfig
+		// staticConfigs are the indexes of the static configs, by scheme
+		// and metrics path.
+		staticConfigs := make(map[[2]string]int)
+		for _, u := range urls {
+			pu, err := url.Parse(u)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse prometheus url: %v", err)
+			}
+			metricsPath := pu.Path + "federate"
+			if sc == nil {
+				sc = &ScrapeConfig{
+					JobName:        fmt.Sprintf("federate_%s", group),
+					ScrapeInterval: time.Duration(modules.DefaultScrapeSettings.ScrapeInterval),
+					ScrapeTimeout:  time.Duration(modules.DefaultScrapeSettings.ScrapeTimeout),
+					MetricsPath:    metricsPath,
+					Scheme:         pu.Scheme,
+					HonorLabels:    true,
+					Params: url.Values{
+						"match[]": m.cfg.Federation.Match,
+					},
+				}
+			}
+			key := [2]string{pu.Scheme, metricsPath}
+			i, ok := staticConfigs[key]
+			if !ok {
+				labels := map[string]string{}
+				if pu.Scheme != sc.Scheme {
+					labels[model.SchemeLabel] = pu.Scheme
+				}
+				if metricsPath != sc.MetricsPath {
+					labels[model.MetricsPathLabel] = metricsPath
+				}
+				i = len(sc.StaticConfigs)
+				staticConfigs[key] = i
+				sc.StaticConfigs = append(sc.StaticConfigs, StaticConfig{Labels: labels})
+			}
+			sc.StaticConfigs[i].Targets = append(sc.StaticConfigs[i].Targets, pu.Host)
+		}
+		scrapeConfigs = append(scrapeConfigs, *sc)
+	}
+	return scrapeConfigs, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+)
+
+// fileSDDir is the directory where the prometheus role copies the
+// prometheus_static_targets_files on the Prometheus hosts.
+const fileSDDir = "{{ prometheus_config_dir }}/file_sd"
+
+// fileSDName returns the name of the file SD file of a job and target group.
+func fileSDName(job, tg string) string {
+	return fmt.Sprintf("%s_%s.json", job, tg)
+}
+
+// writeFileSDFiles writes the targets of each job and target group to
+// <dir>/<job>_<target group>.json, and removes the other JSON files of dir.
+// It returns the files written, by target group.
+func writeFileSDFiles(dir string, scrapeTargets map[string]map[string][]labels.Labels) (map[string][]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create file_sd directory: %v", err)
+	}
+
+	files := make(map[string][]string)
+	written := make(map[string]bool)
+	for tg, jobs := range scrapeTargets {
+		for job, targets := range jobs {
+			data, err := json.MarshalIndent(staticConfigs(targets), "", "  ")
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal file_sd file: %v", err)
+			}
+
+			name := fileSDName(job, tg)
+			file := filepath.Join(dir, name)
+			if err := os.WriteFile(file, data, 0644); err != nil {
+				return nil, fmt.Errorf("could not write file_sd file: %v", err)
+			}
+			files[tg] = append(files[tg], file)
+			written[name] = true
+		}
+	}
+
+	// Remove the files of the jobs and target groups that are gone.
+	stale, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stale {
+		if !written[filepath.Base(file)] {
+			if err := os.Remove(file); err != nil {
+				return nil, fmt.Errorf("could not remove stale file_sd file: %v", err)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// fileSDCleanupTasks are the tasks that remove, from the Prometheus hosts, the
+// file_sd files that are not in o11y_prometheus_file_sd_names, so that the
+// targets of the target groups and modules that are gone are not scraped
+// anymore. Like for the rules files, the prometheus role does not remove them.
+func fileSDCleanupTasks() []ansible.Task {
+	return cleanupTasks("file_sd", fileSDDir, "*.json", "o11y_prometheus_file_sd_names", "")
+}
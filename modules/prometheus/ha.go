@@ -0,0 +1,98 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// HAConfig configures the Prometheus servers of a target group as replicas of
+// the same cluster. Every replica scrapes the same targets and gets the
+// cluster and replica external labels, so that their data can be deduplicated.
+type HAConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Cluster is the value of the cluster label. It defaults to the name of
+	// the target group.
+	Cluster      string `yaml:"cluster,omitempty"`
+	ClusterLabel string `yaml:"cluster_label"`
+	ReplicaLabel string `yaml:"replica_label"`
+}
+
+// Validate checks the label names of the HA configuration.
+func (c HAConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !model.LabelName(c.ClusterLabel).IsValid() {
+		return fmt.Errorf("invalid cluster_label %q", c.ClusterLabel)
+	}
+	if !model.LabelName(c.ReplicaLabel).IsValid() {
+		return fmt.Errorf("invalid replica_label %q", c.ReplicaLabel)
+	}
+	if c.ClusterLabel == c.ReplicaLabel {
+		return errors.New("cluster_label and replica_label must be different")
+	}
+	return nil
+}
+
+// cluster returns the name of the cluster of the Prometheus servers of a
+// target group.
+func (m *Module) cluster(group string) string {
+	if m.cfg.HA.Cluster != "" {
+		return m.cfg.HA.Cluster
+	}
+	return group
+}
+
+// replica returns the name of the replica running on a target: its host.
+func replica(target labels.Labels) (string, error) {
+	addr := target.Get(model.AddressLabel)
+	if addr == "" {
+		return "", fmt.Errorf("__address__ label not found in label set")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, nil
+	}
+	return host, nil
+}
+
+// externalLabels returns the external labels of the replica running on a
+// target.
+func (m *Module) externalLabels(target labels.Labels, group string) (map[string]string, error) {
+	r, err := replica(target)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		m.cfg.HA.ClusterLabel: m.cluster(group),
+		m.cfg.HA.ReplicaLabel: r,
+	}, nil
+}
+
+// alertRelabelConfigs drops the replica label from the alerts, so that the
+// Alertmanagers deduplicate the alerts sent by every replica.
+func (m *Module) alertRelabelConfigs() []map[string]string {
+	return []map[string]string{
+		{
+			"action": "labeldrop",
+			"regex":  m.cfg.HA.ReplicaLabel,
+		},
+	}
+}
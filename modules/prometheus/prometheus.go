@@ -15,23 +15,24 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
-	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"time"
 
 	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/rulefmt"
 	"github.com/roidelapluie/o11y-deploy/model/ansible"
-	"github.com/roidelapluie/o11y-deploy/model/ctx"
 	"github.com/roidelapluie/o11y-deploy/model/promserver"
 	"github.com/roidelapluie/o11y-deploy/modules"
 	"github.com/roidelapluie/o11y-deploy/util"
-	"gopkg.in/yaml.v3"
 )
 
 var DefaultConfig = ModuleConfig{
@@ -39,6 +40,20 @@ var DefaultConfig = ModuleConfig{
 	PrometheusVersion: "2.48.0",
 	ListenAddress:     "0.0.0.0",
 	ListenPort:        "9090",
+
+	StoragePath:         "/var/lib/prometheus",
+	RetentionTime:       "30d",
+	WALCompression:      true,
+	QueryMaxConcurrency: 20,
+	QueryTimeout:        "2m",
+
+	HA: HAConfig{
+		ClusterLabel: "cluster",
+		ReplicaLabel: "replica",
+	},
+	Federation: FederationConfig{
+		Match: []string{`{__name__=~".+:.+"}`},
+	},
 }
 
 func init() {
@@ -50,6 +65,39 @@ type ModuleConfig struct {
 	PrometheusVersion string `yaml:"prometheus_version"`
 	ListenAddress     string `yaml:"listen_address"`
 	ListenPort        string `yaml:"listen_port"`
+
+	// StoragePath is the directory of the TSDB. RetentionSize is a size
+	// like 512MB or 100GB; when it is empty, only RetentionTime applies.
+	StoragePath    string `yaml:"storage_path"`
+	RetentionTime  string `yaml:"retention_time"`
+	RetentionSize  string `yaml:"retention_size,omitempty"`
+	WALCompression bool   `yaml:"wal_compression"`
+
+	QueryMaxConcurrency int    `yaml:"query_max_concurrency"`
+	QueryTimeout        string `yaml:"query_timeout"`
+
+	// EnableFeatures are passed to Prometheus with --enable-feature.
+	EnableFeatures []string `yaml:"enable_features,omitempty"`
+
+	// FileSD makes the scrape jobs use file based service discovery instead
+	// of static configs, so that target changes do not change the main
+	// configuration file.
+	FileSD bool `yaml:"file_sd"`
+
+	Scrape modules.ScrapeSettings `yaml:"scrape"`
+
+	// Agent runs Prometheus in agent mode: it only scrapes the targets and
+	// sends the samples to the remote_write destinations.
+	Agent bool `yaml:"agent"`
+
+	HA HAConfig `yaml:"ha"`
+
+	Federation FederationConfig `yaml:"federation"`
+
+	// RemoteWrite and RemoteRead are passed to Prometheus as they are. File
+	// paths are paths on the Prometheus servers.
+	RemoteWrite []*promconfig.RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	RemoteRead  []*promconfig.RemoteReadConfig  `yaml:"remote_read,omitempty"`
 }
 
 func (m *ModuleConfig) Name() string {
@@ -57,17 +105,36 @@ func (m *ModuleConfig) Name() string {
 }
 
 type ScrapeConfig struct {
-	JobName        string         `yaml:"job_name"`
-	ScrapeInterval time.Duration  `yaml:"scrape_interval"`
-	ScrapeTimeout  time.Duration  `yaml:"scrape_timeout"`
-	MetricsPath    string         `yaml:"metrics_path"`
-	Scheme         string         `yaml:"scheme"`
-	StaticConfigs  []StaticConfig `yaml:"static_configs"`
+	JobName              string             `yaml:"job_name"`
+	ScrapeInterval       time.Duration      `yaml:"scrape_interval"`
+	ScrapeTimeout        time.Duration      `yaml:"scrape_timeout"`
+	MetricsPath          string             `yaml:"metrics_path"`
+	Scheme               string             `yaml:"scheme"`
+	SampleLimit          uint               `yaml:"sample_limit,omitempty"`
+	TLSConfig            *modules.TLSConfig `yaml:"tls_config,omitempty"`
+	BasicAuth            *modules.BasicAuth `yaml:"basic_auth,omitempty"`
+	BearerTokenFile      string             `yaml:"bearer_token_file,omitempty"`
+	HonorLabels          bool               `yaml:"honor_labels,omitempty"`
+	Params               url.Values         `yaml:"params,omitempty"`
+	StaticConfigs        []StaticConfig     `yaml:"static_configs,omitempty"`
+	FileSDConfigs        []FileSDConfig     `yaml:"file_sd_configs,omitempty"`
+	RelabelConfigs       []RelabelConfig    `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []*relabel.Config  `yaml:"metric_relabel_configs,omitempty"`
+}
+
+// RelabelConfig is a relabel config that sets a label to a fixed value.
+type RelabelConfig struct {
+	TargetLabel string `yaml:"target_label"`
+	Replacement string `yaml:"replacement"`
 }
 
 type StaticConfig struct {
-	Targets []string          `yaml:"targets"`
-	Labels  map[string]string `yaml:"labels"`
+	Targets []string          `yaml:"targets" json:"targets"`
+	Labels  map[string]string `yaml:"labels" json:"labels"`
+}
+
+type FileSDConfig struct {
+	Files []string `yaml:"files"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
@@ -83,9 +150,10 @@ func (m *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *ModuleConfig) NewModule(modules.ModuleOptions) (modules.Module, error) {
+func (m *ModuleConfig) NewModule(o modules.ModuleOptions) (modules.Module, error) {
 	return &Module{
-		cfg: m,
+		cfg:  m,
+		opts: o,
 	}, nil
 }
 
@@ -94,115 +162,210 @@ func (m *ModuleConfig) IsEnabled() bool {
 	return m.Enabled
 }
 
+// Ports implements the modules.PortsConfig interface.
+func (m *ModuleConfig) Ports() []string {
+	return []string{m.ListenPort}
+}
+
+// HighlyAvailable implements the modules.ClusterConfig interface. Agents can
+// not be queried, they do not form clusters.
+func (m *ModuleConfig) HighlyAvailable() bool {
+	return m.HA.Enabled && !m.Agent
+}
+
+// Singleton implements the modules.SingletonConfig interface.
+func (m *ModuleConfig) Singleton() bool {
+	return true
+}
+
+// ScrapeSettings implements the modules.ScrapedConfig interface.
+func (m *ModuleConfig) ScrapeSettings() modules.ScrapeSettings {
+	return m.Scrape
+}
+
+// Validate implements the modules.Validator interface.
+func (m *ModuleConfig) Validate() error {
+	if err := m.Scrape.Validate(); err != nil {
+		return fmt.Errorf("scrape: %w", err)
+	}
+	if err := m.validateStorage(); err != nil {
+		return err
+	}
+	if m.Agent {
+		if len(m.RemoteWrite) == 0 {
+			return errors.New("agent: remote_write is required in agent mode")
+		}
+		if m.Federation.Enabled {
+			return errors.New("agent: federation is not supported in agent mode")
+		}
+		if len(m.RemoteRead) > 0 {
+			return errors.New("agent: remote_read is not supported in agent mode")
+		}
+	}
+	if err := m.HA.Validate(); err != nil {
+		return fmt.Errorf("ha: %w", err)
+	}
+	if err := m.Federation.Validate(); err != nil {
+		return fmt.Errorf("federation: %w", err)
+	}
+	for i, rw := range m.RemoteWrite {
+		if err := validateRemote(rw.URL, rw.HTTPClientConfig); err != nil {
+			return fmt.Errorf("remote_write[%d]: %w", i, err)
+		}
+	}
+	for i, rr := range m.RemoteRead {
+		if err := validateRemote(rr.URL, rr.HTTPClientConfig); err != nil {
+			return fmt.Errorf("remote_read[%d]: %w", i, err)
+		}
+	}
+	if err := modules.ValidatePort(m.ListenPort); err != nil {
+		return fmt.Errorf("listen_port: %w", err)
+	}
+	if net.ParseIP(m.ListenAddress) == nil {
+		return fmt.Errorf("listen_address: invalid IP address %q", m.ListenAddress)
+	}
+	return nil
+}
+
 type Module struct {
-	cfg *ModuleConfig
+	cfg  *ModuleConfig
+	opts modules.ModuleOptions
 }
 
-func (m *Module) Playbook(c context.Context) (*ansible.Playbook, error) {
-	scrapeConfigs := []ScrapeConfig{}
-	staticConfigMap := labelsToStaticConfigs(ctx.GetPromTargets(c))
-
-	for job, staticConfig := range staticConfigMap {
-		scrapeConfigs = append(scrapeConfigs, ScrapeConfig{
-			JobName:        job,
-			ScrapeInterval: 15 * time.Second,
-			ScrapeTimeout:  10 * time.Second,
-			MetricsPath:    "/metrics",
-			Scheme:         "http",
-			StaticConfigs:  staticConfig,
-		})
+func (m *Module) Playbook(c context.Context, dm *modules.DeploymentModel) (*ansible.Playbook, error) {
+	scrapeTargets := make(map[string]map[string][]labels.Labels, len(dm.Groups))
+	rulesMap := make(map[string]map[string][]rulefmt.RuleGroup, len(dm.Groups))
+	for _, g := range dm.Groups {
+		scrapeTargets[g.Name] = g.ScrapeTargets
+		rulesMap[g.Name] = g.RuleGroups
 	}
 
-	sort.Slice(scrapeConfigs, func(i, j int) bool {
-		return scrapeConfigs[i].JobName < scrapeConfigs[j].JobName
-	})
+	// The files of every target group are written, the directories are
+	// shared by the Prometheus servers of all the target groups, but each
+	// server only gets the files of the target groups it scrapes.
+	scraped := m.scrapedGroups(dm)
 
-	rulesFiles := []string{}
+	sdFiles := []string{}
+	if m.cfg.FileSD {
+		files, err := writeFileSDFiles(dm.Global.FileSDDir, scrapeTargets)
+		if err != nil {
+			return nil, err
+		}
+		sdFiles = groupFiles(files, scraped)
+	}
 
-	rulesMap := ctx.GetPromRules(c)
-	rulesDir, err := os.MkdirTemp("", "prometheus_rules_*")
+	scrapeConfigs, err := m.scrapeConfigs(dm)
 	if err != nil {
-		return nil, fmt.Errorf("could not create rules directory: %v", err)
+		return nil, err
 	}
 
-	for tg, rules := range rulesMap {
-		rulesTgDir := filepath.Join(rulesDir, tg)
-		if err = os.Mkdir(rulesTgDir, 0750); err != nil {
-			return nil, fmt.Errorf("could not create rules subdirectory: %v", err)
+	// Agents do not evaluate rules and do not send alerts.
+	rulesFiles := []string{}
+	amConfig := []map[string]interface{}{}
+	if !m.cfg.Agent {
+		if err := checkLoadedRules(dm, scraped); err != nil {
+			return nil, err
 		}
-
-		rulesFile, err := os.Create(filepath.Join(rulesTgDir, "prometheus.rules"))
+		files, err := writeRulesFiles(dm.Global.RulesDir, rulesMap)
 		if err != nil {
-			return nil, fmt.Errorf("could not create rules file: %v", err)
+			return nil, err
 		}
+		rulesFiles = groupFiles(files, scraped)
 
-		rulesLink := filepath.Join(os.TempDir(), fmt.Sprintf("%v.rules", tg))
-		if _, err := os.Stat(rulesLink); err == nil {
-			// Link exists; delete it so we can relink to the new version
-			os.Remove(rulesLink)
-		}
-
-		err = os.Symlink(rulesFile.Name(), rulesLink)
+		amConfig, err = alertmanagerConfig(dm)
 		if err != nil {
-			return nil, fmt.Errorf("could not link rules directory: %v", err)
+			return nil, err
 		}
+	}
 
-		rulesFiles = append(rulesFiles, rulesLink)
-
-		ruleGroups := rulefmt.RuleGroups{
-			Groups: rules,
+	vars := map[string]interface{}{
+		"prometheus_version":                m.cfg.PrometheusVersion,
+		"prometheus_db_dir":                 m.cfg.StoragePath,
+		"prometheus_storage_retention":      m.cfg.RetentionTime,
+		"prometheus_storage_retention_size": m.cfg.retentionSize(),
+		"prometheus_config_flags_extra":     m.cfg.flags(),
+		"prometheus_scrape_configs":         scrapeConfigs,
+		"prometheus_alert_rules":            []string{},
+		"prometheus_alert_rules_files":      []string{},
+		"o11y_prometheus_rules_files":       playbookPaths(dm.Global.RulesPath, rulesFiles),
+		"o11y_prometheus_rules_names":       fileNames(rulesFiles),
+		"o11y_prometheus_file_sd_names":     fileNames(sdFiles),
+		"prometheus_static_targets_files":   playbookPaths(dm.Global.FileSDPath, sdFiles),
+		"prometheus_remote_write":           m.remoteWrite(),
+		"prometheus_remote_read":            m.remoteRead(),
+		"prometheus_web_external_url":       "{{o11y_prometheus_external_address}}",
+		"prometheus_web_listen_address":     net.JoinHostPort(m.cfg.ListenAddress, m.cfg.ListenPort),
+		"prometheus_alertmanager_config":    amConfig,
+		"prometheus_agent_mode":             m.cfg.Agent,
+	}
+	if m.cfg.HA.Enabled {
+		vars["prometheus_external_labels"] = "{{ o11y_prometheus_external_labels }}"
+		if !m.cfg.Agent {
+			vars["prometheus_alert_relabel_configs"] = m.alertRelabelConfigs()
 		}
+	}
 
-		rulesYaml, err := yaml.Marshal(ruleGroups)
-		if err != nil {
-			return nil, fmt.Errorf("could not marshal rules file: %v", err)
-		}
+	return &ansible.Playbook{
+		Name:   "Linux",
+		Vars:   vars,
+		Tasks:  append(rulesTasks(), fileSDCleanupTasks()...),
+		Hosts:  "all",
+		Become: true,
+		Roles: []ansible.Role{
+			{
+				Name: "prometheus",
+			},
+		},
+	}, nil
+}
 
-		if _, err := rulesFile.Write([]byte(rulesYaml)); err != nil {
-			return nil, fmt.Errorf("could not write rules file: %v", err)
+// groupFiles returns the files of the given target groups, sorted.
+func groupFiles(files map[string][]string, groups map[string]bool) []string {
+	res := []string{}
+	for group, fs := range files {
+		if groups[group] {
+			res = append(res, fs...)
 		}
+	}
+	sort.Strings(res)
+	return res
+}
 
+// playbookPaths returns the paths of the files in dir, the directory that
+// contains them as the playbooks refer to it. If dir is empty, the files are
+// returned unchanged.
+func playbookPaths(dir string, files []string) []string {
+	if dir == "" {
+		return files
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, path.Join(dir, filepath.Base(f)))
 	}
+	return paths
+}
 
-	ams := ctx.GetAlertmanagerServers(c)
+// alertmanagerConfig returns the alerting configuration that sends the alerts
+// to every Alertmanager of the deployment, through the portal.
+func alertmanagerConfig(dm *modules.DeploymentModel) ([]map[string]interface{}, error) {
 	amsString := []string{}
-	for _, am := range ams {
+	for _, am := range dm.Global.AlertmanagerServers {
 		amURL, err := url.Parse(am.URL)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse alertmanager url: %v", err)
 		}
 		amsString = append(amsString, amURL.Hostname())
 	}
-
-	return &ansible.Playbook{
-		Name: "Linux",
-		Vars: map[string]interface{}{
-			"prometheus_version":              m.cfg.PrometheusVersion,
-			"prometheus_scrape_configs":       scrapeConfigs,
-			"prometheus_alert_rules":          []string{},
-			"prometheus_alert_rules_files":    rulesFiles,
-			"prometheus_static_targets_files": []string{},
-			"prometheus_web_external_url":     "{{o11y_prometheus_external_address}}",
-			"prometheus_web_listen_address":   net.JoinHostPort(m.cfg.ListenAddress, m.cfg.ListenPort),
-			"prometheus_alertmanager_config": []map[string]interface{}{
+	return []map[string]interface{}{
+		{
+			"scheme":      "http",
+			"path_prefix": "/alertmanager",
+			"static_configs": []map[string][]string{
 				{
-					"scheme":      "http",
-					"path_prefix": "/alertmanager",
-					"static_configs": []map[string][]string{
-						{
-							"targets": amsString,
-						},
-					},
+					"targets": amsString,
 				},
 			},
 		},
-		Hosts:  "all",
-		Become: true,
-		Roles: []ansible.Role{
-			{
-				Name: "prometheus",
-			},
-		},
 	}, nil
 }
 
@@ -211,13 +374,21 @@ func (m *Module) HostVars(target labels.Labels, group string) (map[string]interf
 	if err != nil {
 		return nil, err
 	}
-	return map[string]interface{}{
+	vars := map[string]interface{}{
 		"o11y_prometheus_external_address": addr,
-	}, nil
+	}
+	if m.cfg.Enabled && m.cfg.HA.Enabled {
+		el, err := m.externalLabels(target, group)
+		if err != nil {
+			return nil, err
+		}
+		vars["o11y_prometheus_external_labels"] = el
+	}
+	return vars, nil
 }
 
 func (m *Module) GetTargets(targets []labels.Labels, group string) ([]labels.Labels, error) {
-	return modules.GetTargets(targets, "9090", group)
+	return modules.GetTargets(targets, m.cfg.ListenPort, group)
 }
 
 func (m *Module) ReverseProxy(targets []labels.Labels, group string) ([]modules.ReverseProxyEntry, error) {
@@ -238,46 +409,47 @@ func (m *Module) ReverseProxy(targets []labels.Labels, group string) ([]modules.
 }
 
 func (m *Module) GetPrometheusServers(targets []labels.Labels, group string) ([]promserver.PrometheusServer, error) {
-	rp, err := modules.GetReverseProxy(targets, "9090", m.cfg.Name(), "/prometheus", group)
+	rp, err := modules.GetReverseProxy(targets, m.cfg.ListenPort, m.cfg.Name(), "/prometheus", group)
 	if err != nil {
 		return nil, err
 	}
 	promservers := []promserver.PrometheusServer{}
 	for _, r := range rp {
-		promservers = append(promservers, promserver.PrometheusServer{
-			Name: r.Name,
-			URL:  r.URL + r.Prefix,
-		})
+		s := promserver.PrometheusServer{
+			Name:       r.Name,
+			URL:        r.URL + r.Prefix,
+			Group:      group,
+			Agent:      m.cfg.Agent,
+			Federation: m.cfg.Federation.Enabled,
+		}
+		if m.cfg.HA.Enabled {
+			s.Cluster = m.cluster(group)
+			s.Replica = r.Host
+		}
+		promservers = append(promservers, s)
 	}
 	return promservers, nil
 }
 
-func labelsToStaticConfigs(labelSetList map[string]map[string][]labels.Labels) map[string][]StaticConfig {
-	staticConfigsMap := make(map[string][]StaticConfig)
+// staticConfigs returns a static config per target.
+func staticConfigs(targets []labels.Labels) []StaticConfig {
+	scs := make([]StaticConfig, 0, len(targets))
+	for _, labelSet := range targets {
+		staticConfig := StaticConfig{
+			Targets: make([]string, 0, 1),
+			Labels:  make(map[string]string, len(labelSet)),
+		}
 
-	for _, l := range labelSetList {
-		for job, k := range l {
-			if _, ok := staticConfigsMap[job]; !ok {
-				staticConfigsMap[job] = make([]StaticConfig, 0, len(l))
-			}
-			for _, labelSet := range k {
-				staticConfig := StaticConfig{
-					Targets: make([]string, 0, 1),
-					Labels:  make(map[string]string, len(labelSet)),
-				}
-
-				instance := ""
-				for _, label := range labelSet {
-					if label.Name == model.AddressLabel {
-						instance = label.Value
-					} else {
-						staticConfig.Labels[label.Name] = label.Value
-					}
-				}
-				staticConfig.Targets = append(staticConfig.Targets, instance)
-				staticConfigsMap[job] = append(staticConfigsMap[job], staticConfig)
+		instance := ""
+		for _, label := range labelSet {
+			if label.Name == model.AddressLabel {
+				instance = label.Value
+			} else {
+				staticConfig.Labels[label.Name] = label.Value
 			}
 		}
+		staticConfig.Targets = append(staticConfig.Targets, instance)
+		scs = append(scs, staticConfig)
 	}
-	return staticConfigsMap
+	return scs
 }
@@ -1,12 +1,663 @@
 package prometheus
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/model/amserver"
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+	"github.com/roidelapluie/o11y-deploy/model/promserver"
 	"github.com/roidelapluie/o11y-deploy/modules"
+	"gopkg.in/yaml.v3"
 )
 
 func TestInterface(*testing.T) {
 	var _ modules.ReverseProxiedModule = &Module{}
 	var _ modules.PrometheusModule = &Module{}
 }
+
+func TestPlaybook(t *testing.T) {
+	cfg := DefaultConfig
+	m, err := cfg.NewModule(modules.ModuleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rulesDir := t.TempDir()
+	// Written by a previous version.
+	if err := os.WriteFile(filepath.Join(rulesDir, "servers.rules"), []byte("groups: []"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dm := &modules.DeploymentModel{
+		Global: modules.GlobalModel{
+			RulesDir: rulesDir,
+			AlertmanagerServers: []amserver.AlertmanagerServer{
+				{Name: "alertmanager", URL: "http://am1:9093/alertmanager/"},
+			},
+		},
+		Groups: []modules.GroupModel{
+			{
+				Name: "servers",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", "host1:9100", "group_name", "servers")},
+				},
+				RuleGroups: map[string][]rulefmt.RuleGroup{
+					"linux":        {{Name: "servers-linux", Rules: []rulefmt.RuleNode{{Record: yaml.Node{Kind: yaml.ScalarNode, Value: "a:b"}}}}},
+					"alertmanager": {{Name: "servers-alertmanager"}},
+				},
+			},
+			{
+				Name: "db",
+				RuleGroups: map[string][]rulefmt.RuleGroup{
+					"linux": {{Name: "db-linux", Rules: []rulefmt.RuleNode{{Record: yaml.Node{Kind: yaml.ScalarNode, Value: "a:b"}}}}},
+				},
+			},
+		},
+	}
+
+	pb, err := m.Playbook(context.Background(), dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrapeConfigs := pb.Vars["prometheus_scrape_configs"].([]ScrapeConfig)
+	if len(scrapeConfigs) != 1 || scrapeConfigs[0].JobName != "linux_servers" {
+		t.Fatalf("unexpected scrape configs: %v", scrapeConfigs)
+	}
+
+	// One file per target group and module with rules.
+	files := pb.Vars["o11y_prometheus_rules_files"].([]string)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 rules files, got %v", files)
+	}
+	for i, prefix := range []string{"db_linux_", "servers_linux_"} {
+		if name := filepath.Base(files[i]); !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".rules") {
+			t.Fatalf("unexpected rules file name %q", name)
+		}
+	}
+	// Each file only contains the rules of its own target group.
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "db-linux") || strings.Contains(string(data), "servers-linux") {
+		t.Fatalf("unexpected rules in %s:\n%s", files[0], data)
+	}
+	if _, err := os.Stat(filepath.Join(rulesDir, "servers.rules")); !os.IsNotExist(err) {
+		t.Fatal("expected the stale rules file to be removed")
+	}
+	if !reflect.DeepEqual(pb.Vars["o11y_prometheus_rules_names"], []string{filepath.Base(files[0]), filepath.Base(files[1])}) {
+		t.Fatalf("unexpected rules names %v", pb.Vars["o11y_prometheus_rules_names"])
+	}
+
+	// The role does not copy the rules files: the stale files are removed,
+	// then the current ones are copied, in the same block.
+	if files := pb.Vars["prometheus_alert_rules_files"].([]string); len(files) != 0 {
+		t.Fatalf("expected the role not to copy the rules files, got %v", files)
+	}
+	var steps []string
+	for _, task := range pb.Tasks {
+		block, ok := task.Config["block"].([]ansible.Task)
+		if !ok {
+			continue
+		}
+		for _, bt := range block {
+			switch {
+			case bt.Config["ansible.builtin.file"] != nil && bt.Config["loop"] != nil:
+				steps = append(steps, "remove")
+			case bt.Config["ansible.builtin.copy"] != nil:
+				steps = append(steps, "copy")
+			}
+		}
+	}
+	if !reflect.DeepEqual(steps, []string{"remove", "copy"}) {
+		t.Fatalf("expected a block removing then copying the rules files, got %v", steps)
+	}
+
+	// The same rules give the same files.
+	pb2, err := m.Playbook(context.Background(), dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pb2.Vars["o11y_prometheus_rules_files"], files) {
+		t.Fatalf("expected the same rules files, got %v", pb2.Vars["o11y_prometheus_rules_files"])
+	}
+}
+
+func TestPlaybookFileSD(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.FileSD = true
+	m, err := cfg.NewModule(modules.ModuleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stale_servers.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dm := &modules.DeploymentModel{
+		Global: modules.GlobalModel{
+			RulesDir:  t.TempDir(),
+			FileSDDir: dir,
+		},
+		Groups: []modules.GroupModel{
+			{
+				Name: "servers",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", "host1:9100", "group_name", "servers")},
+				},
+			},
+		},
+	}
+
+	pb, err := m.Playbook(context.Background(), dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := []string{filepath.Join(dir, "linux_servers.json")}
+	if files := pb.Vars["prometheus_static_targets_files"]; !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("expected file_sd files %v, got %v", expectedFiles, files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale_servers.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected stale file to be removed, got %v", err)
+	}
+	// The stale files are also removed from the Prometheus hosts.
+	if names := pb.Vars["o11y_prometheus_file_sd_names"]; !reflect.DeepEqual(names, []string{"linux_servers.json"}) {
+		t.Fatalf("unexpected file_sd names %v", names)
+	}
+	var cleanup bool
+	for _, task := range pb.Tasks {
+		if find, ok := task.Config["ansible.builtin.find"].(map[string]interface{}); ok && find["paths"] == "{{ prometheus_config_dir }}/file_sd" {
+			cleanup = true
+		}
+	}
+	if !cleanup {
+		t.Fatalf("expected a task to clean up the file_sd directory, got %v", pb.Tasks)
+	}
+
+	scrapeConfigs := pb.Vars["prometheus_scrape_configs"].([]ScrapeConfig)
+	expected := []FileSDConfig{{Files: []string{"{{ prometheus_config_dir }}/file_sd/linux_servers.json"}}}
+	if len(scrapeConfigs) != 1 || len(scrapeConfigs[0].StaticConfigs) != 0 || !reflect.DeepEqual(scrapeConfigs[0].FileSDConfigs, expected) {
+		t.Fatalf("unexpected scrape configs: %+v", scrapeConfigs)
+	}
+
+	data, err := os.ReadFile(expectedFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sd []StaticConfig
+	if err := json.Unmarshal(data, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if len(sd) != 1 || sd[0].Targets[0] != "host1:9100" || sd[0].Labels["group_name"] != "servers" {
+		t.Fatalf("unexpected file_sd content: %s", data)
+	}
+
+	// A rendered playbook refers to the files relatively to itself.
+	dm.Global.FileSDPath = "{{ playbook_dir }}/file_sd"
+	pb, err = m.Playbook(context.Background(), dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles = []string{"{{ playbook_dir }}/file_sd/linux_servers.json"}
+	if files := pb.Vars["prometheus_static_targets_files"]; !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("expected file_sd files %v, got %v", expectedFiles, files)
+	}
+}
+
+func TestScrapeSettings(t *testing.T) {
+	cfg := DefaultConfig
+	m := &Module{cfg: &cfg}
+
+	dm := &modules.DeploymentModel{
+		Groups: []modules.GroupModel{
+			{
+				Name: "servers",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux":      {labels.FromStrings("__address__", "host1:9100")},
+					"prometheus": {labels.FromStrings("__address__", "host1:9090")},
+				},
+				ScrapeSettings: map[string]modules.ScrapeSettings{
+					"linux": {
+						ScrapeInterval:  model.Duration(5 * time.Second),
+						Scheme:          "https",
+						BearerTokenFile: "/etc/prometheus/token",
+					},
+				},
+			},
+		},
+	}
+
+	scrapeConfigs, err := m.scrapeConfigs(dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scrapeConfigs) != 2 {
+		t.Fatalf("expected 2 scrape configs, got %v", scrapeConfigs)
+	}
+
+	linux := scrapeConfigs[0]
+	if linux.JobName != "linux_servers" || linux.ScrapeInterval != 5*time.Second || linux.ScrapeTimeout != 5*time.Second ||
+		linux.Scheme != "https" || linux.MetricsPath != "/metrics" || linux.BearerTokenFile != "/etc/prometheus/token" {
+		t.Fatalf("unexpected linux scrape config: %+v", linux)
+	}
+	if !reflect.DeepEqual(linux.RelabelConfigs, []RelabelConfig{{TargetLabel: "job", Replacement: "linux"}}) {
+		t.Fatalf("unexpected relabel configs: %+v", linux.RelabelConfigs)
+	}
+
+	prom := scrapeConfigs[1]
+	if prom.ScrapeInterval != 15*time.Second || prom.ScrapeTimeout != 10*time.Second || prom.Scheme != "http" {
+		t.Fatalf("unexpected prometheus scrape config: %+v", prom)
+	}
+
+	dm.Groups[0].ScrapeSettings["linux"] = modules.ScrapeSettings{
+		ScrapeInterval: model.Duration(5 * time.Second),
+		ScrapeTimeout:  model.Duration(10 * time.Second),
+	}
+	if _, err := m.scrapeConfigs(dm); err == nil {
+		t.Fatal("expected an error for a timeout greater than the interval")
+	}
+}
+
+func TestValidateRemoteWrite(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{
+			config: `
+remote_write:
+- url: https://remote.example.com/api/v1/write
+  basic_auth:
+    username: prometheus
+    password_file: /etc/prometheus/password
+remote_read:
+- url: https://remote.example.com/api/v1/read
+`,
+		},
+		{
+			config: `
+remote_write:
+- url: https://remote.example.com/api/v1/write
+  basic_auth:
+    username: prometheus
+    password: secret
+`,
+			err: "remote_write[0]: basic_auth: use password_file instead of password",
+		},
+		{
+			config: `
+remote_read:
+- url: ftp://remote.example.com/
+`,
+			err: `remote_read[0]: invalid url "ftp://remote.example.com/", scheme must be http or https`,
+		},
+	} {
+		var cfg ModuleConfig
+		if err := yaml.Unmarshal([]byte(tc.config), &cfg); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		err := cfg.Validate()
+		if tc.err == "" && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestHA(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	cfg.HA.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	mod, err := cfg.NewModule(modules.ModuleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mod.(*Module)
+
+	vars, err := m.HostVars(labels.FromStrings("__address__", "host1:9100"), "servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"cluster": "servers", "replica": "host1"}
+	if !reflect.DeepEqual(vars["o11y_prometheus_external_labels"], expected) {
+		t.Fatalf("expected external labels %v, got %v", expected, vars["o11y_prometheus_external_labels"])
+	}
+
+	servers, err := m.GetPrometheusServers([]labels.Labels{
+		labels.FromStrings("__address__", "host1:9100"),
+		labels.FromStrings("__address__", "host2:9100"),
+	}, "servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range servers {
+		if s.Cluster != "servers" || s.Replica != []string{"host1", "host2"}[i] {
+			t.Fatalf("unexpected server %+v", s)
+		}
+	}
+
+	pb, err := m.Playbook(context.Background(), &modules.DeploymentModel{Global: modules.GlobalModel{RulesDir: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Vars["prometheus_external_labels"] != "{{ o11y_prometheus_external_labels }}" {
+		t.Fatalf("unexpected external labels %v", pb.Vars["prometheus_external_labels"])
+	}
+
+	cfg.HA.ReplicaLabel = "cluster"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error with identical cluster and replica labels")
+	}
+}
+
+func TestFederation(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Federation.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	mod, err := cfg.NewModule(modules.ModuleOptions{TargetGroup: "global"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global := mod.(*Module)
+	siteCfg := DefaultConfig
+	mod, err = siteCfg.NewModule(modules.ModuleOptions{TargetGroup: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := mod.(*Module)
+
+	rules := func(group string) map[string][]rulefmt.RuleGroup {
+		return map[string][]rulefmt.RuleGroup{
+			"linux": {{Name: group + "-linux", Rules: []rulefmt.RuleNode{{Record: yaml.Node{Kind: yaml.ScalarNode, Value: "a:b"}}}}},
+		}
+	}
+	// The edge target group has no Prometheus servers of its own.
+	dm := &modules.DeploymentModel{
+		Global: modules.GlobalModel{
+			RulesDir: t.TempDir(),
+			PrometheusServers: []promserver.PrometheusServer{
+				{Name: "prometheus", URL: "http://global1:9090/prometheus/", Group: "global", Federation: true},
+				{Name: "prometheus", URL: "http://site1:9090/prometheus/", Group: "site"},
+			},
+		},
+		Groups: []modules.GroupModel{
+			{
+				Name: "global",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", "global1:9100")},
+				},
+				RuleGroups: rules("global"),
+			},
+			{
+				Name: "site",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", "site1:9100")},
+				},
+				RuleGroups: rules("site"),
+			},
+			{
+				Name: "edge",
+				ScrapeTargets: map[string][]labels.Labels{
+					"linux": {labels.FromStrings("__address__", "edge1:9100")},
+				},
+				RuleGroups: rules("edge"),
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		m     *Module
+		jobs  []string
+		rules []string
+	}{
+		// The global server federates the site and scrapes the rest.
+		{m: global, jobs: []string{"federate_site", "linux_edge", "linux_global"}, rules: []string{"edge_linux_", "global_linux_"}},
+		// The site server only scrapes its own target group.
+		{m: site, jobs: []string{"linux_site"}, rules: []string{"site_linux_"}},
+	} {
+		pb, err := tc.m.Playbook(context.Background(), dm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scs := pb.Vars["prometheus_scrape_configs"].([]ScrapeConfig)
+		var jobs []string
+		for _, sc := range scs {
+			jobs = append(jobs, sc.JobName)
+		}
+		if !reflect.DeepEqual(jobs, tc.jobs) {
+			t.Fatalf("target group %q: expected jobs %v, got %v", tc.m.opts.TargetGroup, tc.jobs, jobs)
+		}
+		files := pb.Vars["o11y_prometheus_rules_files"].([]string)
+		if len(files) != len(tc.rules) {
+			t.Fatalf("target group %q: expected rules files %v, got %v", tc.m.opts.TargetGroup, tc.rules, files)
+		}
+		for i, prefix := range tc.rules {
+			if !strings.HasPrefix(filepath.Base(files[i]), prefix) {
+				t.Fatalf("target group %q: expected rules files %v, got %v", tc.m.opts.TargetGroup, tc.rules, files)
+			}
+		}
+	}
+
+	scs, err := global.scrapeConfigs(dm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fed := scs[0]
+	if fed.MetricsPath != "/prometheus/federate" || !fed.HonorLabels {
+		t.Fatalf("unexpected federation job %+v", fed)
+	}
+	if !reflect.DeepEqual(fed.Params["match[]"], DefaultConfig.Federation.Match) {
+		t.Fatalf("unexpected match[] %v", fed.Params["match[]"])
+	}
+	if !reflect.DeepEqual(fed.StaticConfigs[0].Targets, []string{"site1:9090"}) {
+		t.Fatalf("unexpected federation targets %v", fed.StaticConfigs[0].Targets)
+	}
+
+	cfg.Federation.Match = []string{"up{"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error with an invalid selector")
+	}
+}
+
+func TestFederationListenPort(t *testing.T) {
+	siteCfg := DefaultConfig
+	siteCfg.ListenPort = "9091"
+	mod, err := siteCfg.NewModule(modules.ModuleOptions{TargetGroup: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := mod.(*Module)
+	targets := []labels.Labels{labels.FromStrings("__address__", "site1:22")}
+
+	mtgs, err := site.GetTargets(targets, "site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := mtgs[0].Get("__address__"); addr != "site1:9091" {
+		t.Fatalf("expected target site1:9091, got %s", addr)
+	}
+	servers, err := site.GetPrometheusServers(targets, "site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].URL != "http://site1:9091/prometheus/" {
+		t.Fatalf("unexpected Prometheus servers %+v", servers)
+	}
+
+	cfg := DefaultConfig
+	cfg.Federation.Enabled = true
+	mod, err = cfg.NewModule(modules.ModuleOptions{TargetGroup: "global"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scs, err := mod.(*Module).scrapeConfigs(&modules.DeploymentModel{
+		Global: modules.GlobalModel{PrometheusServers: servers},
+		Groups: []modules.GroupModel{{Name: "global"}, {Name: "site"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scs) != 1 || !reflect.DeepEqual(scs[0].StaticConfigs[0].Targets, []string{"site1:9091"}) {
+		t.Fatalf("unexpected federation jobs %+v", scs)
+	}
+}
+
+func TestFederationMixedURLs(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Federation.Enabled = true
+	mod, err := cfg.NewModule(modules.ModuleOptions{TargetGroup: "global"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scs, err := mod.(*Module).federationScrapeConfigs(map[string][]string{
+		"site": {"http://site1:9090/prometheus/", "http://site2:9090/", "https://site3:9090/prometheus/", "http://site4:9090/prometheus/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scs) != 1 || scs[0].Scheme != "http" || scs[0].MetricsPath != "/prometheus/federate" {
+		t.Fatalf("unexpected federation jobs %+v", scs)
+	}
+	expected := []StaticConfig{
+		{Targets: []string{"site1:9090", "site4:9090"}, Labels: map[string]string{}},
+		{Targets: []string{"site2:9090"}, Labels: map[string]string{"__metrics_path__": "/federate"}},
+		{Targets: []string{"site3:9090"}, Labels: map[string]string{"__scheme__": "https"}},
+	}
+	if !reflect.DeepEqual(scs[0].StaticConfigs, expected) {
+		t.Fatalf("expected static configs %+v, got %+v", expected, scs[0].StaticConfigs)
+	}
+}
+
+func TestPlaybookDuplicateRules(t *testing.T) {
+	cfg := DefaultConfig
+	m, err := cfg.NewModule(modules.ModuleOptions{TargetGroup: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := rulefmt.RuleNode{Alert: yaml.Node{Kind: yaml.ScalarNode, Value: "Down"}, Expr: yaml.Node{Kind: yaml.ScalarNode, Value: "up == 0"}}
+	group := func(name string) modules.GroupModel {
+		return modules.GroupModel{
+			Name:       name,
+			RuleGroups: map[string][]rulefmt.RuleGroup{"custom": {{Name: "db", Rules: []rulefmt.RuleNode{down}}}},
+			RuleFiles:  map[string][]string{"custom": {name + ".yml"}},
+		}
+	}
+	// The server loads the rules of both target groups.
+	_, err = m.Playbook(context.Background(), &modules.DeploymentModel{
+		Global: modules.GlobalModel{RulesDir: t.TempDir()},
+		Groups: []modules.GroupModel{group("a"), group("b")},
+	})
+	expected := `b.yml: group "db", rule 1, "Down": alert already defined in group "db" at a.yml`
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q in error %v", expected, err)
+	}
+}
+
+func TestValidateStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		set   func(*ModuleConfig)
+		valid bool
+	}{
+		{name: "defaults", set: func(*ModuleConfig) {}, valid: true},
+		{name: "size", set: func(c *ModuleConfig) { c.RetentionSize = "100GB" }, valid: true},
+		{name: "size only", set: func(c *ModuleConfig) { c.RetentionTime = "0s"; c.RetentionSize = "1TB" }, valid: true},
+		{name: "invalid time", set: func(c *ModuleConfig) { c.RetentionTime = "30 days" }},
+		{name: "no retention", set: func(c *ModuleConfig) { c.RetentionTime = "0s" }},
+		{name: "invalid size", set: func(c *ModuleConfig) { c.RetentionSize = "100G" }},
+		{name: "relative path", set: func(c *ModuleConfig) { c.StoragePath = "data" }},
+		{name: "concurrency", set: func(c *ModuleConfig) { c.QueryMaxConcurrency = 0 }},
+		{name: "query timeout", set: func(c *ModuleConfig) { c.QueryTimeout = "2" }},
+		{name: "features", set: func(c *ModuleConfig) { c.EnableFeatures = []string{"memory-snapshot-on-shutdown,exemplar-storage"} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			tc.set(&cfg)
+			err := cfg.Validate()
+			if tc.valid && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestAgent(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	cfg.Agent = true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error without remote_write")
+	}
+	if err := yaml.Unmarshal([]byte(`
+enabled: true
+agent: true
+remote_write:
+- url: https://remote.example.com/api/v1/write
+`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := cfg.NewModule(modules.ModuleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := m.Playbook(context.Background(), &modules.DeploymentModel{
+		Global: modules.GlobalModel{
+			AlertmanagerServers: []amserver.AlertmanagerServer{
+				{Name: "alertmanager", URL: "http://am1:9093/alertmanager/"},
+			},
+		},
+		Groups: []modules.GroupModel{
+			{Name: "edge", RuleGroups: map[string][]rulefmt.RuleGroup{"linux": {{Name: "edge-linux"}}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Vars["prometheus_agent_mode"] != true {
+		t.Fatal("expected agent mode")
+	}
+	if files := pb.Vars["o11y_prometheus_rules_files"].([]string); len(files) != 0 {
+		t.Fatalf("expected no rules files, got %v", files)
+	}
+	if amc := pb.Vars["prometheus_alertmanager_config"].([]map[string]interface{}); len(amc) != 0 {
+		t.Fatalf("expected no alerting config, got %v", amc)
+	}
+	flags := pb.Vars["prometheus_config_flags_extra"].(map[string]interface{})
+	if _, ok := flags["query.timeout"]; ok {
+		t.Fatalf("unexpected query flags in agent mode: %v", flags)
+	}
+
+	servers, err := m.(*Module).GetPrometheusServers([]labels.Labels{labels.FromStrings("__address__", "edge1:22")}, "edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !servers[0].Agent {
+		t.Fatal("expected an agent server")
+	}
+}
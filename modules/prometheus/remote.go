@@ -0,0 +1,64 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/common/config"
+	promconfig "github.com/prometheus/prometheus/config"
+)
+
+// validateRemote checks a remote write or remote read endpoint. The upstream
+// types already validate their values when they are unmarshaled; secrets must
+// also be read from files, so that they do not end up in the playbooks and in
+// the deployment state.
+func validateRemote(u *config.URL, c config.HTTPClientConfig) error {
+	if u == nil || u.URL == nil || u.String() == "" {
+		return errors.New("url is required")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q, scheme must be http or https", u.String())
+	}
+	if c.BasicAuth != nil && c.BasicAuth.Password != "" {
+		return errors.New("basic_auth: use password_file instead of password")
+	}
+	if c.Authorization != nil && c.Authorization.Credentials != "" {
+		return errors.New("authorization: use credentials_file instead of credentials")
+	}
+	if c.BearerToken != "" {
+		return errors.New("use bearer_token_file instead of bearer_token")
+	}
+	if c.OAuth2 != nil && c.OAuth2.ClientSecret != "" {
+		return errors.New("oauth2: use client_secret_file instead of client_secret")
+	}
+	return nil
+}
+
+// remoteWrite returns the remote write configuration of the role.
+func (m *Module) remoteWrite() []*promconfig.RemoteWriteConfig {
+	if m.cfg.RemoteWrite == nil {
+		return []*promconfig.RemoteWriteConfig{}
+	}
+	return m.cfg.RemoteWrite
+}
+
+// remoteRead returns the remote read configuration of the role.
+func (m *Module) remoteRead() []*promconfig.RemoteReadConfig {
+	if m.cfg.RemoteRead == nil {
+		return []*promconfig.RemoteReadConfig{}
+	}
+	return m.cfg.RemoteRead
+}
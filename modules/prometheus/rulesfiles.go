@@ -0,0 +1,187 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/model/ansible"
+	"github.com/roidelapluie/o11y-deploy/modules"
+	"github.com/roidelapluie/o11y-deploy/rules"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFileName returns the name of the rules file of a target group and
+// module, with the hash of its content, so that a change of the rules is a
+// new file.
+func rulesFileName(tg, module string, content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("%s_%s_%s.rules", tg, module, hex.EncodeToString(sum[:])[:16])
+}
+
+// writeRulesFiles writes the rules of each target group and module to
+// <dir>/<target group>_<module>_<hash>.rules, and removes the other rules
+// files of dir. Modules without rules get no file. It returns the files
+// written, by target group.
+func writeRulesFiles(dir string, rulesMap map[string]map[string][]rulefmt.RuleGroup) (map[string][]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create rules directory: %v", err)
+	}
+
+	rulesFiles := make(map[string][]string)
+	written := make(map[string]bool)
+	for tg, modules := range rulesMap {
+		for module, rules := range modules {
+			if !hasRules(rules) {
+				continue
+			}
+			rulesYaml, err := yaml.Marshal(rulefmt.RuleGroups{
+				Groups: rules,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal rules file: %v", err)
+			}
+
+			name := rulesFileName(tg, module, rulesYaml)
+			rulesFile := filepath.Join(dir, name)
+			if err := os.WriteFile(rulesFile, rulesYaml, 0644); err != nil {
+				return nil, fmt.Errorf("could not write rules file: %v", err)
+			}
+			rulesFiles[tg] = append(rulesFiles[tg], rulesFile)
+			written[name] = true
+		}
+	}
+
+	// Remove the files of the old rules, and of the target groups and
+	// modules that are gone.
+	stale, err := filepath.Glob(filepath.Join(dir, "*.rules"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stale {
+		if !written[filepath.Base(file)] {
+			if err := os.Remove(file); err != nil {
+				return nil, fmt.Errorf("could not remove stale rules file: %v", err)
+			}
+		}
+	}
+
+	return rulesFiles, nil
+}
+
+// checkLoadedRules returns an error if an alert is defined with the same
+// labels in several rule groups with the same name, in the rules of the target
+// groups the server loads. Each target group is checked on its own before, but
+// two target groups can have the same rule groups.
+func checkLoadedRules(dm *modules.DeploymentModel, groups map[string]bool) error {
+	var (
+		rgs   []rulefmt.RuleGroup
+		files []string
+	)
+	for _, g := range dm.Groups {
+		if !groups[g.Name] {
+			continue
+		}
+		mods := make([]string, 0, len(g.RuleGroups))
+		for mod := range g.RuleGroups {
+			mods = append(mods, mod)
+		}
+		sort.Strings(mods)
+		for _, mod := range mods {
+			for i, rg := range g.RuleGroups[mod] {
+				var file string
+				if i < len(g.RuleFiles[mod]) {
+					file = g.RuleFiles[mod][i]
+				}
+				rgs = append(rgs, rg)
+				files = append(files, file)
+			}
+		}
+	}
+	errs := rules.DuplicateGroupAlerts(rgs, files)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("invalid rules: %s", strings.Join(msgs, "; "))
+}
+
+// hasRules returns true if one of the rule groups has rules.
+func hasRules(rgs []rulefmt.RuleGroup) bool {
+	for _, rg := range rgs {
+		if len(rg.Rules) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rulesTasks are the tasks that copy the rules files of
+// o11y_prometheus_rules_files to the Prometheus hosts, and remove the ones that
+// are not in o11y_prometheus_rules_names. Prometheus loads every rules file of
+// the rules directory, and the names change with the rules: a stale file would
+// duplicate the alerts. The prometheus role does not remove the old files, so
+// it does not copy them. The stale files are removed first, then the current
+// ones are copied, in a single block, that tags cannot split.
+func rulesTasks() []ansible.Task {
+	dir := "{{ prometheus_config_dir }}/rules"
+	tasks := []ansible.Task{
+		{
+			Name: "Create the Prometheus rules directory",
+			Config: map[string]interface{}{
+				"ansible.builtin.file": map[string]interface{}{
+					"path":  dir,
+					"state": "directory",
+					"owner": "root",
+					"group": "{{ prometheus_system_group }}",
+					"mode":  "0770",
+				},
+			},
+		},
+	}
+	tasks = append(tasks, cleanupTasks("rules", dir, "*.rules", "o11y_prometheus_rules_names", "reload prometheus")...)
+	tasks = append(tasks, ansible.Task{
+		Name: "Copy the Prometheus rules files",
+		Config: map[string]interface{}{
+			"ansible.builtin.copy": map[string]interface{}{
+				"src":   "{{ item }}",
+				"dest":  dir + "/",
+				"owner": "root",
+				"group": "{{ prometheus_system_group }}",
+				"mode":  "0640",
+			},
+			"loop":   "{{ o11y_prometheus_rules_files }}",
+			"notify": "reload prometheus",
+		},
+	})
+	return []ansible.Task{
+		{
+			Name: "Deploy the Prometheus rules files",
+			Config: map[string]interface{}{
+				"block": tasks,
+				"tags":  []string{"prometheus_configure"},
+			},
+		},
+	}
+}
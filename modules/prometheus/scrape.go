@@ -0,0 +1,80 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/roidelapluie/o11y-deploy/modules"
+)
+
+// scrapeConfigs returns a scrape config per module and scraped target group,
+// named <module>_<target group>. The job label of the targets stays the name
+// of the module. The target groups that are federated get a single federation
+// job instead.
+func (m *Module) scrapeConfigs(dm *modules.DeploymentModel) ([]ScrapeConfig, error) {
+	scrapeConfigs, err := m.federationScrapeConfigs(m.federatedGroups(dm))
+	if err != nil {
+		return nil, err
+	}
+	scraped := m.scrapedGroups(dm)
+	for _, g := range dm.Groups {
+		if !scraped[g.Name] {
+			continue
+		}
+		for job, targets := range g.ScrapeTargets {
+			overrides := g.ScrapeSettings[job]
+			settings := modules.DefaultScrapeSettings.Merge(overrides)
+			// Like Prometheus does for its global default, shorten the
+			// default timeout when the interval is shorter.
+			if overrides.ScrapeTimeout == 0 && settings.ScrapeTimeout > settings.ScrapeInterval {
+				settings.ScrapeTimeout = settings.ScrapeInterval
+			}
+			if err := settings.Validate(); err != nil {
+				return nil, fmt.Errorf("target group %q: job %q: %w", g.Name, job, err)
+			}
+
+			sc := ScrapeConfig{
+				JobName:              fmt.Sprintf("%s_%s", job, g.Name),
+				ScrapeInterval:       time.Duration(settings.ScrapeInterval),
+				ScrapeTimeout:        time.Duration(settings.ScrapeTimeout),
+				MetricsPath:          settings.MetricsPath,
+				Scheme:               settings.Scheme,
+				SampleLimit:          settings.SampleLimit,
+				TLSConfig:            settings.TLSConfig,
+				BasicAuth:            settings.BasicAuth,
+				BearerTokenFile:      settings.BearerTokenFile,
+				MetricRelabelConfigs: settings.MetricRelabelConfigs,
+				RelabelConfigs: []RelabelConfig{
+					{TargetLabel: "job", Replacement: job},
+				},
+			}
+			if m.cfg.FileSD {
+				sc.FileSDConfigs = []FileSDConfig{
+					{Files: []string{fileSDDir + "/" + fileSDName(job, g.Name)}},
+				}
+			} else {
+				sc.StaticConfigs = staticConfigs(targets)
+			}
+			scrapeConfigs = append(scrapeConfigs, sc)
+		}
+	}
+
+	sort.Slice(scrapeConfigs, func(i, j int) bool {
+		return scrapeConfigs[i].JobName < scrapeConfigs[j].JobName
+	})
+	return scrapeConfigs, nil
+}
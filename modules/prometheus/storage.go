@@ -0,0 +1,87 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/units"
+	"github.com/prometheus/common/model"
+)
+
+// validateStorage checks the storage and query settings.
+func (m *ModuleConfig) validateStorage() error {
+	if !filepath.IsAbs(m.StoragePath) {
+		return fmt.Errorf("storage_path: %q is not an absolute path", m.StoragePath)
+	}
+	if d, err := model.ParseDuration(m.RetentionTime); err != nil {
+		return fmt.Errorf("retention_time: %w", err)
+	} else if d == 0 && m.RetentionSize == "" {
+		return errors.New("retention_time: must be greater than 0 when retention_size is not set")
+	}
+	if m.RetentionSize != "" {
+		if s, err := units.ParseBase2Bytes(m.RetentionSize); err != nil {
+			return fmt.Errorf("retention_size: %w", err)
+		} else if s < 0 {
+			return fmt.Errorf("retention_size: %q is negative", m.RetentionSize)
+		}
+	}
+	if m.QueryMaxConcurrency < 1 {
+		return fmt.Errorf("query_max_concurrency: must be greater than 0, got %d", m.QueryMaxConcurrency)
+	}
+	if d, err := model.ParseDuration(m.QueryTimeout); err != nil {
+		return fmt.Errorf("query_timeout: %w", err)
+	} else if d == 0 {
+		return errors.New("query_timeout: must be greater than 0")
+	}
+	for _, f := range m.EnableFeatures {
+		if f == "" || strings.ContainsAny(f, ", \t") {
+			return fmt.Errorf("enable_features: invalid feature %q", f)
+		}
+	}
+	return nil
+}
+
+// flags returns the extra command line flags of Prometheus. The role sets the
+// storage path and retention flags. Agents have their own storage, and no
+// query engine.
+func (m *ModuleConfig) flags() map[string]interface{} {
+	flags := map[string]interface{}{}
+	storage := "storage.agent."
+	if !m.Agent {
+		storage = "storage.tsdb."
+		flags["query.max-concurrency"] = m.QueryMaxConcurrency
+		flags["query.timeout"] = m.QueryTimeout
+	}
+	if m.WALCompression {
+		flags[storage+"wal-compression"] = ""
+	} else {
+		flags["no-"+storage+"wal-compression"] = ""
+	}
+	if len(m.EnableFeatures) > 0 {
+		flags["enable-feature"] = m.EnableFeatures
+	}
+	return flags
+}
+
+// retentionSize returns the retention size, as the role expects it.
+func (m *ModuleConfig) retentionSize() string {
+	if m.RetentionSize == "" {
+		return "0"
+	}
+	return m.RetentionSize
+}
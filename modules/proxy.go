@@ -7,8 +7,17 @@ type ReverseProxiedModule interface {
 }
 
 type ReverseProxyEntry struct {
-	Name   string `yaml:"name"`
-	URL    string `yaml:"url"`
-	Prefix string `yaml:"prefix"`
-	Host   string `yaml:"host"`
+	Name   string `yaml:"name" json:"name"`
+	URL    string `yaml:"url" json:"url"`
+	Prefix string `yaml:"prefix" json:"prefix"`
+	Host   string `yaml:"host" json:"host"`
+}
+
+// DatasourceProxy is a proxy of the highly available Prometheus clusters.
+type DatasourceProxy struct {
+	// URL is the base URL of the proxy, a cluster is served under
+	// <URL>/<cluster>/.
+	URL string `json:"url"`
+	// Group is the target group of the proxy.
+	Group string `json:"group"`
 }
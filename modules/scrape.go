@@ -0,0 +1,119 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// ScrapeSettings are the settings Prometheus uses to scrape the targets of a
+// module. Unset fields keep their default value. File paths are paths on the
+// Prometheus servers.
+type ScrapeSettings struct {
+	ScrapeInterval       model.Duration    `yaml:"scrape_interval,omitempty" json:"scrape_interval,omitempty"`
+	ScrapeTimeout        model.Duration    `yaml:"scrape_timeout,omitempty" json:"scrape_timeout,omitempty"`
+	Scheme               string            `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	MetricsPath          string            `yaml:"metrics_path,omitempty" json:"metrics_path,omitempty"`
+	TLSConfig            *TLSConfig        `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+	BasicAuth            *BasicAuth        `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+	BearerTokenFile      string            `yaml:"bearer_token_file,omitempty" json:"bearer_token_file,omitempty"`
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs,omitempty" json:"metric_relabel_configs,omitempty"`
+	SampleLimit          uint              `yaml:"sample_limit,omitempty" json:"sample_limit,omitempty"`
+}
+
+// DefaultScrapeSettings are the scrape settings of the jobs that do not
+// override them.
+var DefaultScrapeSettings = ScrapeSettings{
+	ScrapeInterval: model.Duration(15 * time.Second),
+	ScrapeTimeout:  model.Duration(10 * time.Second),
+	MetricsPath:    "/metrics",
+	Scheme:         "http",
+}
+
+// TLSConfig configures TLS for scraping.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+}
+
+// BasicAuth configures basic authentication for scraping.
+type BasicAuth struct {
+	Username     string `yaml:"username" json:"username"`
+	PasswordFile string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+}
+
+// A ScrapedConfig is the Config of a module with targets, that can override
+// how they are scraped.
+type ScrapedConfig interface {
+	ScrapeSettings() ScrapeSettings
+}
+
+// Merge returns the settings of s, overridden by the fields set in o.
+func (s ScrapeSettings) Merge(o ScrapeSettings) ScrapeSettings {
+	if o.ScrapeInterval != 0 {
+		s.ScrapeInterval = o.ScrapeInterval
+	}
+	if o.ScrapeTimeout != 0 {
+		s.ScrapeTimeout = o.ScrapeTimeout
+	}
+	if o.Scheme != "" {
+		s.Scheme = o.Scheme
+	}
+	if o.MetricsPath != "" {
+		s.MetricsPath = o.MetricsPath
+	}
+	if o.TLSConfig != nil {
+		s.TLSConfig = o.TLSConfig
+	}
+	if o.BasicAuth != nil {
+		s.BasicAuth = o.BasicAuth
+	}
+	if o.BearerTokenFile != "" {
+		s.BearerTokenFile = o.BearerTokenFile
+	}
+	if o.MetricRelabelConfigs != nil {
+		s.MetricRelabelConfigs = o.MetricRelabelConfigs
+	}
+	if o.SampleLimit != 0 {
+		s.SampleLimit = o.SampleLimit
+	}
+	return s
+}
+
+// Validate checks the settings that are set.
+func (s ScrapeSettings) Validate() error {
+	switch s.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("invalid scheme %q, must be http or https", s.Scheme)
+	}
+	if s.ScrapeInterval != 0 && s.ScrapeTimeout > s.ScrapeInterval {
+		return fmt.Errorf("scrape_timeout %s is greater than scrape_interval %s", s.ScrapeTimeout, s.ScrapeInterval)
+	}
+	if s.BasicAuth != nil && s.BearerTokenFile != "" {
+		return errors.New("at most one of basic_auth and bearer_token_file must be configured")
+	}
+	if s.BasicAuth != nil && s.BasicAuth.Username == "" {
+		return errors.New("basic_auth: username is required")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import "sync"
+
+// Store is a key/value store shared by the instances of a module during a
+// deployment. It is safe for concurrent use.
+type Store struct {
+	mtx    sync.Mutex
+	values map[string]interface{}
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		values: make(map[string]interface{}),
+	}
+}
+
+// Get returns the value stored under key, and whether it was found.
+func (s *Store) Get(key string) (interface{}, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set stores value under key.
+func (s *Store) Set(key string, value interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.values[key] = value
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// AlertOverride changes an alerting rule of a module. Unset fields keep the
+// value of the module.
+type AlertOverride struct {
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Expr replaces the expression, e.g. to change a threshold.
+	Expr string          `yaml:"expr,omitempty"`
+	For  *model.Duration `yaml:"for,omitempty"`
+	// Labels and Annotations are merged with the ones of the rule, e.g. to
+	// change the severity.
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// AlertOverrides are alert overrides, by alert name.
+type AlertOverrides map[string]AlertOverride
+
+// Validate checks the expressions and the label names of the overrides.
+func (o AlertOverrides) Validate() error {
+	for _, name := range o.names() {
+		ao := o[name]
+		if ao.Expr != "" {
+			if _, err := parser.ParseExpr(ao.Expr); err != nil {
+				return fmt.Errorf("alert %q: could not parse expression: %w", name, err)
+			}
+		}
+		for ln := range ao.Labels {
+			if !model.LabelName(ln).IsValid() || ln == model.AlertNameLabel {
+				return fmt.Errorf("alert %q: invalid label name %q", name, ln)
+			}
+		}
+		for an := range ao.Annotations {
+			if !model.LabelName(an).IsValid() {
+				return fmt.Errorf("alert %q: invalid annotation name %q", name, an)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply returns the rule groups with the overrides applied. The disabled
+// alerts are removed. The rule groups are not modified.
+func (o AlertOverrides) Apply(rgs []rulefmt.RuleGroup) []rulefmt.RuleGroup {
+	if len(o) == 0 {
+		return rgs
+	}
+	res := make([]rulefmt.RuleGroup, 0, len(rgs))
+	for _, rg := range rgs {
+		org := rg
+		org.Rules = make([]rulefmt.RuleNode, 0, len(rg.Rules))
+		for _, r := range rg.Rules {
+			ao, ok := o[r.Alert.Value]
+			if !ok || r.Alert.Value == "" {
+				org.Rules = append(org.Rules, r)
+				continue
+			}
+			if ao.Disabled {
+				continue
+			}
+			if ao.Expr != "" {
+				r.Expr.Value = ao.Expr
+			}
+			if ao.For != nil {
+				r.For = *ao.For
+			}
+			r.Labels = merge(r.Labels, ao.Labels)
+			r.Annotations = merge(r.Annotations, ao.Annotations)
+			org.Rules = append(org.Rules, r)
+		}
+		res = append(res, org)
+	}
+	return res
+}
+
+// Unknown returns the names of the overridden alerts that are not in the rule
+// groups, sorted.
+func (o AlertOverrides) Unknown(rgs []rulefmt.RuleGroup) []string {
+	known := make(map[string]bool)
+	for _, rg := range rgs {
+		for _, r := range rg.Rules {
+			known[r.Alert.Value] = true
+		}
+	}
+	var unknown []string
+	for _, name := range o.names() {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+func (o AlertOverrides) names() []string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merge returns a copy of a, with the values of b.
+func merge(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rules checks the Prometheus rules of the modules before they are
+// deployed.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+// Check validates rule groups like Prometheus does when it loads a rules
+// file, including the parsing of the PromQL expressions and of the templates,
+// then lints them. The rule groups are checked as one rules file, as they are
+// deployed. files are the files the rule groups were read from, by index, so
+// that the errors point at the rules in these files. They can be nil or empty
+// for the rule groups of the modules, which do not come from a file.
+// scrapeInterval is the interval at which the series used by the rules are
+// scraped.
+func Check(rgs []rulefmt.RuleGroup, files []string, scrapeInterval time.Duration) []error {
+	if errs := Validate(rgs, files); len(errs) > 0 {
+		return errs
+	}
+	return Lint(rgs, files, scrapeInterval)
+}
+
+// Validate validates rule groups like Prometheus does when it loads a rules
+// file. files are the files the rule groups were read from, like for Check.
+func Validate(rgs []rulefmt.RuleGroup, files []string) []error {
+	var errs []error
+	names := make(map[string]bool, len(rgs))
+	for j, rg := range rgs {
+		file := source(files, j)
+		if rg.Name == "" {
+			errs = append(errs, fmt.Errorf("%sgroup %d: group name must not be empty", position(file, yaml.Node{}), j+1))
+		}
+		if names[rg.Name] {
+			errs = append(errs, fmt.Errorf("%sgroup %q: group name is repeated", position(file, yaml.Node{}), rg.Name))
+		}
+		names[rg.Name] = true
+		for i, r := range rg.Rules {
+			for _, we := range r.Validate() {
+				// The error of the wrapped error does not have the position
+				// of the node, which is not known for the rules of the
+				// modules.
+				errs = append(errs, ruleError(file, rg, i, "%v", errors.Unwrap(&we)))
+			}
+		}
+	}
+	return errs
+}
+
+// Lint returns the problems of the alerting rules that Prometheus accepts, but
+// that are likely mistakes. The rule groups must be valid. files are the files
+// the rule groups were read from, like for Check.
+func Lint(rgs []rulefmt.RuleGroup, files []string, scrapeInterval time.Duration) []error {
+	var errs []error
+	for j, rg := range rgs {
+		file := source(files, j)
+		for i, r := range rg.Rules {
+			if r.Alert.Value == "" {
+				continue
+			}
+			lintErr := func(format string, args ...interface{}) {
+				errs = append(errs, ruleError(file, rg, i, format, args...))
+			}
+			if r.Labels["severity"] == "" {
+				lintErr("missing severity label")
+			}
+			for _, a := range []string{"summary", "description"} {
+				if r.Annotations[a] == "" {
+					lintErr("missing %s annotation", a)
+				}
+			}
+			if r.For > 0 && time.Duration(r.For) < scrapeInterval {
+				lintErr("for %s is shorter than the scrape interval %s", r.For, scrapeInterval)
+			}
+		}
+	}
+	return errs
+}
+
+// DuplicateAlerts returns an error for each alert that is defined in several
+// rule groups, even if they have the same name. An alert can be defined
+// several times in the same rule group, with different thresholds. files are
+// the files the rule groups were read from, like for Check.
+func DuplicateAlerts(rgs []rulefmt.RuleGroup, files []string) []error {
+	return duplicates(rgs, files, func(rg rulefmt.RuleGroup, r rulefmt.RuleNode) string {
+		return r.Alert.Value
+	})
+}
+
+// DuplicateGroupAlerts returns an error for each alert that is defined in
+// several rule groups with the same name, with the same labels. It is meant
+// for the rules of several target groups, loaded by the same Prometheus
+// server: the alerts of the modules are in a rule group per target group, and
+// the rules of the target groups have the label of their target group. files
+// are the files the rule groups were read from, like for Check.
+func DuplicateGroupAlerts(rgs []rulefmt.RuleGroup, files []string) []error {
+	return duplicates(rgs, files, func(rg rulefmt.RuleGroup, r rulefmt.RuleNode) string {
+		return rg.Name + "\xff" + r.Alert.Value + "\xff" + labels.FromMap(r.Labels).String()
+	})
+}
+
+// duplicates returns an error for each alert whose key was already seen in
+// another rule group.
+func duplicates(rgs []rulefmt.RuleGroup, files []string, key func(rulefmt.RuleGroup, rulefmt.RuleNode) string) []error {
+	type definition struct {
+		group int
+		rule  int
+	}
+	var errs []error
+	seen := make(map[string]definition)
+	for j, rg := range rgs {
+		for i, r := range rg.Rules {
+			if r.Alert.Value == "" {
+				continue
+			}
+			k := key(rg, r)
+			if d, ok := seen[k]; ok && d.group != j {
+				first := rgs[d.group].Rules[d.rule].Alert
+				errs = append(errs, ruleError(source(files, j), rg, i, "alert already defined in group %q%s", rgs[d.group].Name, at(source(files, d.group), first)))
+				continue
+			}
+			seen[k] = definition{group: j, rule: i}
+		}
+	}
+	return errs
+}
+
+// ruleError returns an error about the rule i of a rule group, with its
+// position in file if it is known.
+func ruleError(file string, rg rulefmt.RuleGroup, i int, format string, args ...interface{}) error {
+	name := rg.Rules[i].Alert
+	if name.Value == "" {
+		name = rg.Rules[i].Record
+	}
+	return fmt.Errorf("%sgroup %q, rule %d, %q: %s", position(file, name), rg.Name, i+1, name.Value, fmt.Sprintf(format, args...))
+}
+
+// source returns the file of the rule group i, or an empty string if it does
+// not come from a file.
+func source(files []string, i int) string {
+	if i < len(files) {
+		return files[i]
+	}
+	return ""
+}
+
+// position returns the prefix of the errors about a node of file, like
+// file:line:column: , with what is known of them.
+func position(file string, n yaml.Node) string {
+	switch {
+	case file != "" && n.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: ", file, n.Line, n.Column)
+	case file != "":
+		return file + ": "
+	case n.Line > 0:
+		return fmt.Sprintf("%d:%d: ", n.Line, n.Column)
+	}
+	return ""
+}
+
+// at returns where a node is defined, for the error messages, if it is known.
+func at(file string, n yaml.Node) string {
+	if p := strings.TrimSuffix(position(file, n), ": "); p != "" {
+		return " at " + p
+	}
+	return ""
+}
@@ -0,0 +1,228 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+func node(value string) yaml.Node {
+	return yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+func alert(name, expr string, labels, annotations map[string]string) rulefmt.RuleNode {
+	return rulefmt.RuleNode{
+		Alert:       node(name),
+		Expr:        node(expr),
+		For:         model.Duration(time.Minute),
+		Labels:      labels,
+		Annotations: annotations,
+	}
+}
+
+var (
+	severity    = map[string]string{"severity": "warning"}
+	annotations = map[string]string{"summary": "s", "description": "d"}
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rule     rulefmt.RuleNode
+		interval time.Duration
+		errs     []string
+	}{
+		{
+			name: "valid",
+			rule: alert("Up", "up == 0", severity, annotations),
+		},
+		{
+			name: "invalid expression",
+			rule: alert("Up", "up ==", severity, annotations),
+			errs: []string{`group "g", rule 1, "Up": could not parse expression`},
+		},
+		{
+			name: "missing severity",
+			rule: alert("Up", "up == 0", nil, annotations),
+			errs: []string{`a.rules: group "g", rule 1, "Up": missing severity label`},
+		},
+		{
+			name: "position",
+			rule: func() rulefmt.RuleNode {
+				r := alert("Up", "up ==", severity, annotations)
+				r.Alert.Line, r.Alert.Column = 4, 12
+				return r
+			}(),
+			errs: []string{`a.rules:4:12: group "g", rule 1, "Up": could not parse expression`},
+		},
+		{
+			name: "missing annotations",
+			rule: alert("Up", "up == 0", severity, map[string]string{"summary": "s"}),
+			errs: []string{`group "g", rule 1, "Up": missing description annotation`},
+		},
+		{
+			name:     "short for",
+			rule:     alert("Up", "up == 0", severity, annotations),
+			interval: 2 * time.Minute,
+			errs:     []string{`for 1m is shorter than the scrape interval 2m0s`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := Check([]rulefmt.RuleGroup{{Name: "g", Rules: []rulefmt.RuleNode{tc.rule}}}, []string{"a.rules"}, tc.interval)
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("expected %d errors, got %v", len(tc.errs), errs)
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tc.errs[i]) {
+					t.Fatalf("expected error %q, got %q", tc.errs[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateAlerts(t *testing.T) {
+	errs := DuplicateAlerts([]rulefmt.RuleGroup{
+		{Name: "servers-linux", Rules: []rulefmt.RuleNode{alert("Down", "up == 0", severity, annotations)}},
+		{Name: "servers-prometheus", Rules: []rulefmt.RuleNode{alert("Down", "up == 0", severity, annotations)}},
+	}, nil)
+	if len(errs) != 1 || errs[0].Error() != `group "servers-prometheus", rule 1, "Down": alert already defined in group "servers-linux"` {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// Groups with the same name, from two files.
+	down := alert("Down", "up == 0", severity, annotations)
+	down.Alert.Line, down.Alert.Column = 5, 12
+	errs = DuplicateAlerts([]rulefmt.RuleGroup{
+		{Name: "db", Rules: []rulefmt.RuleNode{down, down}},
+		{Name: "db", Rules: []rulefmt.RuleNode{down}},
+	}, []string{"a.rules", "b.rules"})
+	if len(errs) != 1 || errs[0].Error() != `b.rules:5:12: group "db", rule 1, "Down": alert already defined in group "db" at a.rules:5:12` {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestDuplicateGroupAlerts(t *testing.T) {
+	down := alert("Down", "up == 0", severity, annotations)
+	errs := DuplicateGroupAlerts([]rulefmt.RuleGroup{
+		{Name: "a-linux", Rules: []rulefmt.RuleNode{down}},
+		{Name: "b-linux", Rules: []rulefmt.RuleNode{down}},
+		{Name: "db", Rules: []rulefmt.RuleNode{down}},
+		{Name: "db", Rules: []rulefmt.RuleNode{down}},
+	}, []string{"", "", "a.rules", "b.rules"})
+	if len(errs) != 1 || errs[0].Error() != `b.rules: group "db", rule 1, "Down": alert already defined in group "db" at a.rules` {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// The rules of different target groups have different labels.
+	scoped, err := Scope([]rulefmt.RuleGroup{{Name: "db", Rules: []rulefmt.RuleNode{down}}}, "group_name", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Scope([]rulefmt.RuleGroup{{Name: "db", Rules: []rulefmt.RuleNode{down}}}, "group_name", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := DuplicateGroupAlerts(append(scoped, other...), nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestParseTestFile(t *testing.T) {
+	if _, err := ParseTestFile([]byte("rule_files: [a.rules]\ntests: []\n")); err == nil {
+		t.Fatal("expected an error with rule_files")
+	}
+	tf, err := ParseTestFile([]byte("tests: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.EvaluationInterval != model.Duration(time.Minute) {
+		t.Fatalf("unexpected evaluation interval %v", tf.EvaluationInterval)
+	}
+}
+
+func TestScope(t *testing.T) {
+	rgs := []rulefmt.RuleGroup{
+		{
+			Name: "g",
+			Rules: []rulefmt.RuleNode{
+				{Record: node("job:up:sum"), Expr: node(`sum by (job) (up) / count(rate(http_requests_total[5m]))`)},
+			},
+		},
+	}
+	scoped, err := Scope(rgs, "group_name", "servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `sum by (job) (up{group_name="servers"}) / count(rate(http_requests_total{group_name="servers"}[5m]))`
+	if scoped[0].Rules[0].Expr.Value != expected {
+		t.Fatalf("expected %s, got %s", expected, scoped[0].Rules[0].Expr.Value)
+	}
+	if scoped[0].Rules[0].Labels["group_name"] != "servers" {
+		t.Fatalf("expected the group_name label, got %v", scoped[0].Rules[0].Labels)
+	}
+	if rgs[0].Rules[0].Labels != nil {
+		t.Fatal("the rule groups were modified")
+	}
+
+	// The label of the target group can not be selected or set by the
+	// rules.
+	for _, r := range []rulefmt.RuleNode{
+		{Record: node("job:up:sum"), Expr: node(`sum by (job) (rate(up{group_name="other"}[5m]))`)},
+		{Record: node("job:up:sum"), Expr: node(`sum by (job) (up)`), Labels: map[string]string{"group_name": "other"}},
+	} {
+		if _, err := Scope([]rulefmt.RuleGroup{{Name: "g", Rules: []rulefmt.RuleNode{r}}}, "group_name", "servers"); err == nil {
+			t.Fatalf("expected an error with rule %+v", r)
+		}
+	}
+}
+
+func TestAlertOverrides(t *testing.T) {
+	rgs := []rulefmt.RuleGroup{
+		{
+			Name: "servers-prometheus",
+			Rules: []rulefmt.RuleNode{
+				alert("PrometheusBadConfig", "prometheus_config_last_reload_successful == 0", severity, annotations),
+				alert("PrometheusNotificationQueueRunningFull", "prometheus_notifications_queue_length > 0", severity, annotations),
+			},
+		},
+	}
+	d := model.Duration(5 * time.Minute)
+	o := AlertOverrides{
+		"PrometheusNotificationQueueRunningFull": {Disabled: true},
+		"PrometheusBadConfig": {
+			Expr:   "prometheus_config_last_reload_successful < 1",
+			For:    &d,
+			Labels: map[string]string{"severity": "critical"},
+		},
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if unknown := o.Unknown(rgs); len(unknown) != 0 {
+		t.Fatalf("unexpected unknown alerts %v", unknown)
+	}
+
+	res := o.Apply(rgs)
+	if len(res[0].Rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(res[0].Rules))
+	}
+	r := res[0].Rules[0]
+	if r.Expr.Value != "prometheus_config_last_reload_successful < 1" || r.For != d || r.Labels["severity"] != "critical" || r.Annotations["summary"] != "s" {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+	if len(rgs[0].Rules) != 2 || severity["severity"] != "warning" {
+		t.Fatal("the rule groups were modified")
+	}
+
+	if unknown := (AlertOverrides{"Typo": {Disabled: true}}).Unknown(rgs); len(unknown) != 1 || unknown[0] != "Typo" {
+		t.Fatalf("expected the Typo alert to be unknown, got %v", unknown)
+	}
+	if err := (AlertOverrides{"Up": {Expr: "up =="}}).Validate(); err == nil {
+		t.Fatal("expected an error with an invalid expression")
+	}
+}
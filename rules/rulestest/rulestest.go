@@ -0,0 +1,38 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rulestest runs rules unit tests files from Go tests.
+package rulestest
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/roidelapluie/o11y-deploy/rules"
+)
+
+// Run runs the rules unit tests file filename against the rule groups, and
+// fails the test for each failure.
+func Run(t testing.TB, filename string, rgs ...rulefmt.RuleGroup) {
+	t.Helper()
+	tf, err := rules.LoadTestFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range rules.Check(rgs, nil, 0) {
+		t.Errorf("invalid rules: %v", err)
+	}
+	for _, err := range tf.Run(rgs) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Scope restricts the rules to the series of a target group: every selector
+// of their expressions gets a name="value" matcher, and the rules get the
+// label, so that their results keep it. Rules that already select or set the
+// label are an error. The rule groups are not modified.
+func Scope(rgs []rulefmt.RuleGroup, name, value string) ([]rulefmt.RuleGroup, error) {
+	scoped := make([]rulefmt.RuleGroup, 0, len(rgs))
+	for _, rg := range rgs {
+		srg := rg
+		srg.Rules = make([]rulefmt.RuleNode, 0, len(rg.Rules))
+		for i, r := range rg.Rules {
+			if _, ok := r.Labels[name]; ok {
+				return nil, fmt.Errorf("group %q, rule %d: label %q is set by the target group", rg.Name, i+1, name)
+			}
+			expr, err := scopeExpr(r.Expr.Value, name, value)
+			if err != nil {
+				return nil, fmt.Errorf("group %q, rule %d: %w", rg.Name, i+1, err)
+			}
+			r.Expr.Value = expr
+
+			lbls := make(map[string]string, len(r.Labels)+1)
+			for k, v := range r.Labels {
+				lbls[k] = v
+			}
+			lbls[name] = value
+			r.Labels = lbls
+
+			srg.Rules = append(srg.Rules, r)
+		}
+		scoped = append(scoped, srg)
+	}
+	return scoped, nil
+}
+
+// scopeExpr adds a name="value" matcher to every selector of a PromQL
+// expression. Selectors that already have a matcher for name are an error.
+func scopeExpr(query, name, value string) (string, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return query, err
+	}
+	err = parser.Walk(scopeVisitor{name: name, value: value}, expr, nil)
+	if err != nil {
+		return query, err
+	}
+	return expr.String(), nil
+}
+
+// scopeVisitor adds a matcher to the vector selectors it visits.
+type scopeVisitor struct {
+	name, value string
+}
+
+// Visit implements the parser.Visitor interface.
+func (v scopeVisitor) Visit(node parser.Node, path []parser.Node) (parser.Visitor, error) {
+	n, ok := node.(*parser.VectorSelector)
+	if !ok {
+		return v, nil
+	}
+	for _, l := range n.LabelMatchers {
+		if l.Name == v.name {
+			return nil, fmt.Errorf("selector %s: label %q is selected by the target group", n, v.name)
+		}
+	}
+	n.LabelMatchers = append(n.LabelMatchers, labels.MustNewMatcher(labels.MatchEqual, v.name, v.value))
+	return v, nil
+}
@@ -0,0 +1,467 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	promrules "github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// TestFile is a rules unit tests file. It has the format of the files of
+// promtool test rules, without rule_files: the rules under test are given
+// when the tests are run.
+type TestFile struct {
+	EvaluationInterval model.Duration `yaml:"evaluation_interval,omitempty"`
+	GroupEvalOrder     []string       `yaml:"group_eval_order"`
+	Tests              []TestGroup    `yaml:"tests"`
+}
+
+// TestGroup is a group of input series and the tests that use them.
+type TestGroup struct {
+	Interval        model.Duration   `yaml:"interval"`
+	InputSeries     []Series         `yaml:"input_series"`
+	AlertRuleTests  []AlertTestCase  `yaml:"alert_rule_test,omitempty"`
+	PromqlExprTests []PromqlTestCase `yaml:"promql_expr_test,omitempty"`
+	ExternalLabels  labels.Labels    `yaml:"external_labels,omitempty"`
+	ExternalURL     string           `yaml:"external_url,omitempty"`
+	TestGroupName   string           `yaml:"name,omitempty"`
+}
+
+// Series is an input series, with its values in the expanding notation of
+// the PromQL tests.
+type Series struct {
+	Series string `yaml:"series"`
+	Values string `yaml:"values"`
+}
+
+// AlertTestCase checks the alerts that are firing at a given time.
+type AlertTestCase struct {
+	EvalTime  model.Duration `yaml:"eval_time"`
+	Alertname string         `yaml:"alertname"`
+	ExpAlerts []Alert        `yaml:"exp_alerts"`
+}
+
+// Alert is an expected alert.
+type Alert struct {
+	ExpLabels      map[string]string `yaml:"exp_labels"`
+	ExpAnnotations map[string]string `yaml:"exp_annotations"`
+}
+
+// PromqlTestCase checks the result of an expression at a given time.
+type PromqlTestCase struct {
+	Expr       string         `yaml:"expr"`
+	EvalTime   model.Duration `yaml:"eval_time"`
+	ExpSamples []Sample       `yaml:"exp_samples"`
+}
+
+// Sample is an expected sample.
+type Sample struct {
+	Labels string  `yaml:"labels"`
+	Value  float64 `yaml:"value"`
+}
+
+// ParseTestFile parses a rules unit tests file. Unknown fields are errors.
+func ParseTestFile(data []byte) (*TestFile, error) {
+	var tf TestFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&tf); err != nil {
+		return nil, err
+	}
+	if tf.EvaluationInterval == 0 {
+		tf.EvaluationInterval = model.Duration(time.Minute)
+	}
+	seen := make(map[string]bool)
+	for _, gn := range tf.GroupEvalOrder {
+		if seen[gn] {
+			return nil, fmt.Errorf("group name repeated in evaluation order: %s", gn)
+		}
+		seen[gn] = true
+	}
+	return &tf, nil
+}
+
+// LoadTestFile parses the rules unit tests file filename.
+func LoadTestFile(filename string) (*TestFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := ParseTestFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filename, err)
+	}
+	return tf, nil
+}
+
+// Run evaluates the rule groups against the input series of the tests, with
+// the Prometheus rules engine, and returns the failures.
+func (tf *TestFile) Run(rgs []rulefmt.RuleGroup) []error {
+	groupOrderMap := make(map[string]int)
+	for i, gn := range tf.GroupEvalOrder {
+		groupOrderMap[gn] = i
+	}
+
+	var errs []error
+	for _, tg := range tf.Tests {
+		errs = append(errs, tg.test(time.Duration(tf.EvaluationInterval), groupOrderMap, rgs)...)
+	}
+	return errs
+}
+
+// groupLoader gives the rule groups under test to the rules manager, as if
+// they were a rules file.
+type groupLoader struct {
+	rgs []rulefmt.RuleGroup
+}
+
+// Load implements the rules.GroupLoader interface.
+func (l groupLoader) Load(string) (*rulefmt.RuleGroups, []error) {
+	data, err := yaml.Marshal(rulefmt.RuleGroups{Groups: l.rgs})
+	if err != nil {
+		return nil, []error{err}
+	}
+	return rulefmt.Parse(data)
+}
+
+// Parse implements the rules.GroupLoader interface.
+func (l groupLoader) Parse(query string) (parser.Expr, error) {
+	return parser.ParseExpr(query)
+}
+
+// test runs the tests of a test group, like promtool does.
+func (tg *TestGroup) test(evalInterval time.Duration, groupOrderMap map[string]int, rgs []rulefmt.RuleGroup) []error {
+	suite, err := promql.NewLazyLoader(nil, tg.seriesLoadingString(), promql.LazyLoaderOpts{
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+	if err != nil {
+		return []error{err}
+	}
+	defer suite.Close()
+	suite.SubqueryInterval = evalInterval
+
+	m := promrules.NewManager(&promrules.ManagerOptions{
+		QueryFunc:   promrules.EngineQueryFunc(suite.QueryEngine(), suite.Storage()),
+		Appendable:  suite.Storage(),
+		Context:     context.Background(),
+		NotifyFunc:  func(ctx context.Context, expr string, alerts ...*promrules.Alert) {},
+		Logger:      log.NewNopLogger(),
+		GroupLoader: groupLoader{rgs: rgs},
+	})
+	groupsMap, errs := m.LoadGroups(time.Duration(tg.Interval), tg.ExternalLabels, tg.ExternalURL, nil, "rules")
+	if errs != nil {
+		return errs
+	}
+	groups := orderedGroups(groupsMap, groupOrderMap)
+
+	mint := time.Unix(0, 0).UTC()
+	maxt := mint.Add(tg.maxEvalTime())
+
+	// Index the alert tests by evaluation time, so that the alerts are
+	// checked while the rules are evaluated.
+	alertsInTest := make(map[model.Duration]map[string]struct{})
+	alertTests := make(map[model.Duration][]AlertTestCase)
+	for _, alert := range tg.AlertRuleTests {
+		if alert.Alertname == "" {
+			return []error{fmt.Errorf("%san item under alert_rule_test misses required attribute alertname at eval_time %v", tg.prefix(), alert.EvalTime)}
+		}
+		if _, ok := alertsInTest[alert.EvalTime]; !ok {
+			alertsInTest[alert.EvalTime] = make(map[string]struct{})
+		}
+		alertsInTest[alert.EvalTime][alert.Alertname] = struct{}{}
+		alertTests[alert.EvalTime] = append(alertTests[alert.EvalTime], alert)
+	}
+	alertEvalTimes := make([]model.Duration, 0, len(alertTests))
+	for k := range alertTests {
+		alertEvalTimes = append(alertEvalTimes, k)
+	}
+	sort.Slice(alertEvalTimes, func(i, j int) bool {
+		return alertEvalTimes[i] < alertEvalTimes[j]
+	})
+
+	for _, g := range groups {
+		for _, r := range g.Rules() {
+			if alertRule, ok := r.(*promrules.AlertingRule); ok {
+				// Mark alerting rules as restored, to ensure the ALERTS
+				// series is created when they run.
+				alertRule.SetRestored(true)
+			}
+		}
+	}
+
+	curr := 0
+	for ts := mint; !ts.After(maxt); ts = ts.Add(evalInterval) {
+		var evalErrs []error
+		suite.WithSamplesTill(ts, func(err error) {
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			for _, g := range groups {
+				g.Eval(suite.Context(), ts)
+				for _, r := range g.Rules() {
+					if r.LastError() != nil {
+						evalErrs = append(evalErrs, fmt.Errorf("%srule: %s, time: %s, err: %v", tg.prefix(), r.Name(), ts.Sub(mint), r.LastError()))
+					}
+				}
+			}
+		})
+		errs = append(errs, evalErrs...)
+		if len(evalErrs) > 0 {
+			return errs
+		}
+
+		// Check the alerts of the evaluation times that fall before the
+		// next evaluation.
+		for curr < len(alertEvalTimes) && ts.Sub(mint) <= time.Duration(alertEvalTimes[curr]) &&
+			time.Duration(alertEvalTimes[curr]) < ts.Add(evalInterval).Sub(mint) {
+			t := alertEvalTimes[curr]
+			got := firingAlerts(groups, alertsInTest[t])
+
+			for _, testcase := range alertTests[t] {
+				gotAlerts := got[testcase.Alertname]
+				var expAlerts labelsAndAnnotations
+				for _, a := range testcase.ExpAlerts {
+					expLabels := labels.FromMap(a.ExpLabels)
+					// The alertname label is added by Prometheus.
+					expLabels = labels.NewBuilder(expLabels).Set(labels.AlertName, testcase.Alertname).Labels(nil)
+					expAlerts = append(expAlerts, labelAndAnnotation{
+						Labels:      expLabels,
+						Annotations: labels.FromMap(a.ExpAnnotations),
+					})
+				}
+				sort.Sort(gotAlerts)
+				sort.Sort(expAlerts)
+
+				if !reflect.DeepEqual(expAlerts, gotAlerts) {
+					errs = append(errs, fmt.Errorf("%salertname: %s, time: %s,\n        exp: %v,\n        got: %v",
+						tg.prefix(), testcase.Alertname, testcase.EvalTime, expAlerts, gotAlerts))
+				}
+			}
+			curr++
+		}
+	}
+
+	for _, testCase := range tg.PromqlExprTests {
+		if err := tg.testExpr(suite, mint, testCase); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// prefix returns the name of the test group, to prefix its failures with.
+func (tg *TestGroup) prefix() string {
+	if tg.TestGroupName == "" {
+		return ""
+	}
+	return fmt.Sprintf("name: %s, ", tg.TestGroupName)
+}
+
+// firingAlerts returns the firing alerts of the rules named in names.
+func firingAlerts(groups []*promrules.Group, names map[string]struct{}) map[string]labelsAndAnnotations {
+	got := make(map[string]labelsAndAnnotations)
+	for _, g := range groups {
+		for _, r := range g.Rules() {
+			ar, ok := r.(*promrules.AlertingRule)
+			if !ok {
+				continue
+			}
+			if _, ok := names[ar.Name()]; !ok {
+				continue
+			}
+			for _, a := range ar.ActiveAlerts() {
+				if a.State == promrules.StateFiring {
+					got[ar.Name()] = append(got[ar.Name()], labelAndAnnotation{
+						Labels:      a.Labels.Copy(),
+						Annotations: a.Annotations.Copy(),
+					})
+				}
+			}
+		}
+	}
+	return got
+}
+
+// testExpr checks the result of an expression.
+func (tg *TestGroup) testExpr(suite *promql.LazyLoader, mint time.Time, testCase PromqlTestCase) error {
+	got, err := query(suite.Context(), testCase.Expr, mint.Add(time.Duration(testCase.EvalTime)), suite.QueryEngine(), suite.Queryable())
+	if err != nil {
+		return fmt.Errorf("%sexpr: %q, time: %s, err: %v", tg.prefix(), testCase.Expr, testCase.EvalTime, err)
+	}
+
+	var gotSamples []parsedSample
+	for _, s := range got {
+		gotSamples = append(gotSamples, parsedSample{
+			Labels: s.Metric.Copy(),
+			Value:  s.V,
+		})
+	}
+	var expSamples []parsedSample
+	for _, s := range testCase.ExpSamples {
+		lb, err := parser.ParseMetric(s.Labels)
+		if err != nil {
+			return fmt.Errorf("%sexpr: %q, time: %s, err: labels %q: %v", tg.prefix(), testCase.Expr, testCase.EvalTime, s.Labels, err)
+		}
+		expSamples = append(expSamples, parsedSample{
+			Labels: lb,
+			Value:  s.Value,
+		})
+	}
+
+	sort.Slice(expSamples, func(i, j int) bool {
+		return labels.Compare(expSamples[i].Labels, expSamples[j].Labels) <= 0
+	})
+	sort.Slice(gotSamples, func(i, j int) bool {
+		return labels.Compare(gotSamples[i].Labels, gotSamples[j].Labels) <= 0
+	})
+	if !reflect.DeepEqual(expSamples, gotSamples) {
+		return fmt.Errorf("%sexpr: %q, time: %s,\n        exp: %v\n        got: %v", tg.prefix(), testCase.Expr,
+			testCase.EvalTime, parsedSamplesString(expSamples), parsedSamplesString(gotSamples))
+	}
+	return nil
+}
+
+// seriesLoadingString returns the input series in the notation of the PromQL
+// tests.
+func (tg *TestGroup) seriesLoadingString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "load %v\n", shortDuration(tg.Interval))
+	for _, is := range tg.InputSeries {
+		fmt.Fprintf(&b, "  %v %v\n", is.Series, is.Values)
+	}
+	return b.String()
+}
+
+func shortDuration(d model.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// orderedGroups returns the rule groups in the order of groupOrderMap. The
+// groups that are not in it are evaluated first.
+func orderedGroups(groupsMap map[string]*promrules.Group, groupOrderMap map[string]int) []*promrules.Group {
+	groups := make([]*promrules.Group, 0, len(groupsMap))
+	for _, g := range groupsMap {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groupOrderMap[groups[i].Name()] < groupOrderMap[groups[j].Name()]
+	})
+	return groups
+}
+
+// maxEvalTime returns the latest evaluation time of the tests.
+func (tg *TestGroup) maxEvalTime() time.Duration {
+	var maxd model.Duration
+	for _, alert := range tg.AlertRuleTests {
+		if alert.EvalTime > maxd {
+			maxd = alert.EvalTime
+		}
+	}
+	for _, pet := range tg.PromqlExprTests {
+		if pet.EvalTime > maxd {
+			maxd = pet.EvalTime
+		}
+	}
+	return time.Duration(maxd)
+}
+
+func query(ctx context.Context, qs string, t time.Time, engine *promql.Engine, qu storage.Queryable) (promql.Vector, error) {
+	q, err := engine.NewInstantQuery(qu, nil, qs, t)
+	if err != nil {
+		return nil, err
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{promql.Sample{
+			Point:  promql.Point{T: v.T, V: v.V},
+			Metric: labels.Labels{},
+		}}, nil
+	default:
+		return nil, errors.New("rule result is not a vector or scalar")
+	}
+}
+
+type labelAndAnnotation struct {
+	Labels      labels.Labels
+	Annotations labels.Labels
+}
+
+func (la labelAndAnnotation) String() string {
+	return "Labels:" + la.Labels.String() + " Annotations:" + la.Annotations.String()
+}
+
+type labelsAndAnnotations []labelAndAnnotation
+
+func (la labelsAndAnnotations) Len() int      { return len(la) }
+func (la labelsAndAnnotations) Swap(i, j int) { la[i], la[j] = la[j], la[i] }
+func (la labelsAndAnnotations) Less(i, j int) bool {
+	diff := labels.Compare(la[i].Labels, la[j].Labels)
+	if diff != 0 {
+		return diff < 0
+	}
+	return labels.Compare(la[i].Annotations, la[j].Annotations) < 0
+}
+
+type parsedSample struct {
+	Labels labels.Labels
+	Value  float64
+}
+
+func (ps parsedSample) String() string {
+	return ps.Labels.String() + " " + strconv.FormatFloat(ps.Value, 'E', -1, 64)
+}
+
+func parsedSamplesString(pss []parsedSample) string {
+	if len(pss) == 0 {
+		return "nil"
+	}
+	s := make([]string, 0, len(pss))
+	for _, ps := range pss {
+		s = append(s, ps.String())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 The O11y Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter prefixes each line with a fixed string. It only writes full
+// lines to the underlying writer, so that several PrefixWriters can share it.
+type PrefixWriter struct {
+	out    io.Writer
+	prefix []byte
+	buffer bytes.Buffer
+	mtx    sync.Mutex
+}
+
+func NewPrefixWriter(out io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{out: out, prefix: []byte(prefix)}
+}
+
+func (pw *PrefixWriter) Write(p []byte) (n int, err error) {
+	pw.mtx.Lock()
+	defer pw.mtx.Unlock()
+
+	pw.buffer.Write(p)
+	for {
+		i := bytes.IndexByte(pw.buffer.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := append(append([]byte{}, pw.prefix...), pw.buffer.Next(i+1)...)
+		if _, err := pw.out.Write(line); err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the last line, if it does not end with a newline.
+func (pw *PrefixWriter) Flush() error {
+	pw.mtx.Lock()
+	defer pw.mtx.Unlock()
+
+	if pw.buffer.Len() == 0 {
+		return nil
+	}
+	line := append(append([]byte{}, pw.prefix...), pw.buffer.Bytes()...)
+	pw.buffer.Reset()
+	_, err := pw.out.Write(append(line, '\n'))
+	return err
+}